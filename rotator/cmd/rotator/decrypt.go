@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/crypt"
+)
+
+// runDecrypt writes the decrypted contents of an archive to stdout or a
+// file. The key is looked up by the ID in the archive's header as a file
+// name in the keys directory.
+func runDecrypt(args []string) {
+	fs := flag.NewFlagSet("decrypt", flag.ExitOnError)
+	keys := fs.String("keys", "/etc/rotator-keys", "Directory holding one key file per key ID")
+	out := fs.String("o", "", "Write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rotator decrypt [-keys dir] [-o file] archive.enc")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := decrypt(fs.Arg(0), *keys, *out); err != nil {
+		fmt.Fprintf(os.Stderr, "decrypt %s: %v\n", fs.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func decrypt(path, keys, out string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r, err := crypt.NewReader(in, crypt.DirKeys(keys))
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		_, err = io.Copy(os.Stdout, r)
+		return err
+	}
+	f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(out)
+		return err
+	}
+	return f.Close()
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -17,6 +18,16 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			runVerify(os.Args[2:])
+			return
+		case "decrypt":
+			runDecrypt(os.Args[2:])
+			return
+		}
+	}
 	cfgPath := flag.String("config", "/etc/rotator/config.yaml", "Path to config file")
 	listen := flag.String("listen", ":9102", "Metrics and health listen address")
 	flag.Parse()
@@ -37,42 +48,104 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
-	disc := discover.New(cfg.Defaults.Discovery, cfg.Overrides)
+	disc := discover.NewRoots(cfg.DiscoveryRoots(), cfg.Overrides, cfg.Defaults.Discovery.ScanWorkers)
+	for _, root := range disc.Roots() {
+		prom.FilesDiscovered.WithLabelValues(root).Set(0)
+	}
 	pol := policy.New(cfg, prom)
 	rot, err := engine.New(cfg, prom, log)
 	if err != nil {
 		log.WithError(err).Fatal("failed to init engine")
 	}
 
-	ticker := time.NewTicker(30 * time.Second)
+	rot.Start(ctx)
+
+	processFile := func(f discover.FileInfo) {
+		eff := pol.EffectivePolicy(f.Group, f.Path)
+		log.WithFields(map[string]interface{}{
+			"file":      f.Path,
+			"namespace": f.Namespace,
+			"group":     f.Group,
+			"size":      f.Size,
+			"threshold": eff.Size,
+		}).Debug("processing file")
+		if err := rot.ProcessFile(ctx, f, eff); err != nil {
+			prom.CountError("process_file")
+			log.WithError(err).WithField("file", f.Path).Warn("process failed")
+		}
+	}
+	process := func(files []discover.FileInfo) {
+		for _, f := range files {
+			processFile(f)
+		}
+		rot.EndCycle(ctx)
+	}
+
+	// file events re-evaluate written files right away; the periodic scan
+	// reconciles and drives the age and inactive triggers
+	var events <-chan []discover.FileInfo
+	if w := cfg.Defaults.Discovery.Watch; w == nil || *w {
+		if events, err = disc.Watch(ctx); err != nil {
+			log.WithError(err).Warn("file events unavailable; relying on periodic scans")
+		}
+	}
+	interval := cfg.Defaults.Discovery.ScanInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+		if events != nil {
+			interval = 5 * time.Minute
+		}
+	}
+	scan := func() {
+		prom.ScanCycles.Inc()
+		// files are processed as the walk streams them in
+		st := disc.Walk(ctx, processFile)
+		if ctx.Err() != nil {
+			return
+		}
+		rot.EndCycle(ctx)
+		for root, n := range st.ByRoot {
+			prom.FilesDiscovered.WithLabelValues(root).Set(float64(n))
+		}
+		prom.ScanDuration.Observe(st.Duration.Seconds())
+		if secs := st.Duration.Seconds(); secs > 0 {
+			prom.ScanFilesPerSecond.Set(float64(st.Files) / secs)
+		}
+		prom.ScanDirs.WithLabelValues("listed").Add(float64(st.Dirs - st.CachedDirs))
+		prom.ScanDirs.WithLabelValues("cached").Add(float64(st.CachedDirs))
+		log.WithFields(map[string]interface{}{
+			"files_found": st.Files,
+			"dirs":        st.Dirs,
+			"dirs_cached": st.CachedDirs,
+			"duration":    st.Duration.String(),
+		}).Info("scan cycle")
+	}
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	log.Info("rotator started")
+	log.WithField("watch", events != nil).WithField("scan_interval", interval.String()).Info("rotator started")
+	if events != nil {
+		// events only cover files written from now on
+		scan()
+	}
 	for {
 		select {
 		case <-ctx.Done():
 			log.Info("shutting down")
+			if err := rot.Close(); err != nil {
+				log.WithError(err).Warn("failed to close journal")
+			}
 			_ = srv.Shutdown(context.Background())
 			return
-		case <-ticker.C:
-			prom.ScanCycles.Inc()
-			files := disc.Scan()
-			prom.FilesDiscovered.Set(float64(len(files)))
-			log.WithField("files_found", len(files)).Info("scan cycle")
-			for _, f := range files {
-				ns := f.Namespace
-				eff := pol.EffectivePolicy(ns, f.Path)
-				log.WithFields(map[string]interface{}{
-					"file":      f.Path,
-					"namespace": ns,
-					"size":      f.Size,
-					"threshold": eff.Size,
-				}).Debug("processing file")
-				if err := rot.ProcessFile(ctx, f, eff); err != nil {
-					prom.CountError("process_file")
-					log.WithError(err).WithField("file", f.Path).Warn("process failed")
-				}
+		case files, ok := <-events:
+			if !ok {
+				events = nil
+				continue
 			}
+			prom.WatchBatches.Inc()
+			process(files)
+		case <-ticker.C:
+			scan()
 		}
 	}
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+)
+
+// runVerify checks archives against their manifests and exits non-zero if
+// any are corrupt, unreadable or missing.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	cfgPath := fs.String("config", "/etc/rotator/config.yaml", "Path to config file")
+	quiet := fs.Bool("quiet", false, "Only print archives that fail")
+	_ = fs.Parse(args)
+
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		os.Exit(2)
+	}
+	counts := map[string]int{}
+	failed := 0
+	engine.Verify(cfg, func(r engine.VerifyResult) {
+		counts[r.Status]++
+		if r.Failed() {
+			failed++
+		} else if *quiet {
+			return
+		}
+		if r.Err != nil {
+			fmt.Printf("%-10s %s: %v\n", r.Status, r.Path, r.Err)
+		} else {
+			fmt.Printf("%-10s %s\n", r.Status, r.Path)
+		}
+	})
+	fmt.Printf("%d ok, %d mismatch, %d unreadable, %d missing, %d unrecorded\n",
+		counts[engine.VerifyOK], counts[engine.VerifyMismatch], counts[engine.VerifyUnreadable],
+		counts[engine.VerifyMissing], counts[engine.VerifyUnrecorded])
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
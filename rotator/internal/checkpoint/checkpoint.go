@@ -0,0 +1,202 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+)
+
+// Supported checkpoint formats.
+const (
+	Fluentd   = "fluentd"   // in_tail pos_file: path\tpos\tinode, both hex
+	Vector    = "vector"    // file source checkpoints.json, device_and_inode fingerprints
+	FluentBit = "fluentbit" // JSON export of the in_tail_files table
+)
+
+// refresh bounds how stale cached positions may be.
+const refresh = 10 * time.Second
+
+// Position is how far a shipper has read one file. Dev is zero when the
+// format does not record it.
+type Position struct {
+	Path   string
+	Dev    uint64
+	Ino    uint64
+	Offset int64
+}
+
+// Set holds the positions of all configured shippers.
+type Set struct {
+	byIno map[uint64][]Position
+}
+
+// Unread reports how many bytes of a file of the given identity and size no
+// shipper has read yet. Files no shipper tracks have nothing unread: the
+// shipper either never followed them or is done and forgot them. When
+// several shippers track a file the furthest behind counts.
+func (s Set) Unread(dev, ino uint64, size int64) int64 {
+	var behind int64
+	for _, p := range s.byIno[ino] {
+		if p.Dev != 0 && dev != 0 && p.Dev != dev {
+			continue
+		}
+		if n := size - p.Offset; n > behind {
+			behind = n
+		}
+	}
+	return behind
+}
+
+// Reader loads shipper checkpoints, caching them briefly.
+type Reader struct {
+	sources []config.CheckpointSource
+
+	mu     sync.Mutex
+	set    Set
+	loaded time.Time
+}
+
+func New(sources []config.CheckpointSource) *Reader {
+	return &Reader{sources: sources}
+}
+
+// Validate checks that every source names a known format.
+func Validate(sources []config.CheckpointSource) error {
+	for _, src := range sources {
+		switch src.Format {
+		case Fluentd, Vector, FluentBit:
+		default:
+			return fmt.Errorf("unknown checkpoint format %q (want %s, %s or %s)", src.Format, Fluentd, Vector, FluentBit)
+		}
+	}
+	return nil
+}
+
+// Positions returns the current positions. Sources that cannot be read are
+// reported in err but do not hide the others; a missing file is not an
+// error, since the shipper may not have written it yet.
+func (r *Reader) Positions() (Set, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loaded.IsZero() && time.Since(r.loaded) < refresh {
+		return r.set, nil
+	}
+	set := Set{byIno: map[uint64][]Position{}}
+	var firstErr error
+	for _, src := range r.sources {
+		paths, err := filepath.Glob(src.Path)
+		if err != nil {
+			return set, err
+		}
+		for _, p := range paths {
+			pos, err := load(src.Format, p)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s checkpoint %s: %w", src.Format, p, err)
+				}
+				continue
+			}
+			for _, ps := range pos {
+				set.byIno[ps.Ino] = append(set.byIno[ps.Ino], ps)
+			}
+		}
+	}
+	r.set, r.loaded = set, time.Now()
+	return set, firstErr
+}
+
+func load(format, path string) ([]Position, error) {
+	switch format {
+	case Fluentd:
+		return loadFluentd(path)
+	case Vector:
+		return loadVector(path)
+	case FluentBit:
+		return loadFluentBit(path)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+func loadFluentd(path string) ([]Position, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var out []Position
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Split(sc.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		pos, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil || pos == 1<<64-1 {
+			// ffffffffffffffff marks a file fluentd stopped watching
+			continue
+		}
+		ino, err := strconv.ParseUint(fields[2], 16, 64)
+		if err != nil {
+			continue
+		}
+		out = append(out, Position{Path: fields[0], Ino: ino, Offset: int64(pos)})
+	}
+	return out, sc.Err()
+}
+
+func loadVector(path string) ([]Position, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Checkpoints []struct {
+			Fingerprint struct {
+				DevInode []uint64 `json:"dev_inode"`
+			} `json:"fingerprint"`
+			Position int64 `json:"position"`
+		} `json:"checkpoints"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	var out []Position
+	for _, c := range doc.Checkpoints {
+		// checksum fingerprints cannot be mapped to a file without reading it
+		if len(c.Fingerprint.DevInode) != 2 {
+			continue
+		}
+		out = append(out, Position{Dev: c.Fingerprint.DevInode[0], Ino: c.Fingerprint.DevInode[1], Offset: c.Position})
+	}
+	return out, nil
+}
+
+// loadFluentBit reads rows exported from Fluent Bit's tail database, e.g.
+// sqlite3 -json tail.db 'select name, offset, inode from in_tail_files'.
+func loadFluentBit(path string) ([]Position, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Name   string `json:"name"`
+		Offset int64  `json:"offset"`
+		Inode  uint64 `json:"inode"`
+	}
+	if err := json.Unmarshal(b, &rows); err != nil {
+		return nil, err
+	}
+	out := make([]Position, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, Position{Path: r.Name, Ino: r.Inode, Offset: r.Offset})
+	}
+	return out, nil
+}
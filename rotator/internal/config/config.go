@@ -3,16 +3,39 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
+// Discovery modes. In host mode files need no namespace or pod: budgets and
+// metrics are kept per group, which the layout may capture as {group} and
+// otherwise defaults to the first path element below the root, and
+// namespace overrides do not apply.
+const (
+	ModeKubernetes = "kubernetes"
+	ModeHost       = "host"
+)
+
+// DiscoveryConfig selects the files to rotate. Layout tells where the
+// namespace, pod and container are in a file's path; it and Mode are set
+// per root and ignored in overrides. Watch, ScanInterval and ScanWorkers
+// only apply to the defaults: files are reported by inotify as they are
+// written and a full walk every ScanInterval reconciles what events missed
+// and drives the age and inactive triggers.
 type DiscoveryConfig struct {
-	Path     string   `yaml:"path"`
-	Include  []string `yaml:"include"`
-	Exclude  []string `yaml:"exclude"`
-	MaxDepth int      `yaml:"maxDepth"`
+	Path         string        `yaml:"path"`
+	Include      []string      `yaml:"include"`
+	Exclude      []string      `yaml:"exclude"`
+	MaxDepth     int           `yaml:"maxDepth"`
+	Mode         string        `yaml:"mode"`         // kubernetes (default) | host
+	Layout       string        `yaml:"layout"`       // default "{namespace}/{pod}/**", or "**" in host mode
+	LayoutRegex  string        `yaml:"layoutRegex"`  // named captures, instead of layout
+	Watch        *bool         `yaml:"watch"`        // default true; false scans only
+	ScanInterval time.Duration `yaml:"scanInterval"` // default 30s, or 5m while watching
+	ScanWorkers  int           `yaml:"scanWorkers"`  // namespaces walked at once, default 4
 }
 
 type PolicyConfig struct {
@@ -22,23 +45,70 @@ type PolicyConfig struct {
 	KeepFiles     int           `yaml:"keepFiles"`
 	KeepDays      int           `yaml:"keepDays"`
 	CompressAfter time.Duration `yaml:"compressAfter"`
-	DefaultMode   string        `yaml:"defaultMode"` // rename | copytruncate
+	DefaultMode   string        `yaml:"defaultMode"`  // rename | copytruncate | trim | auto
+	LineBoundary  *bool         `yaml:"lineBoundary"` // copytruncate: never split a line
+	TrimKeep      ByteSize      `yaml:"trimKeep"`     // trim: newest bytes to keep; default 10Mi
+	TrimMethod    string        `yaml:"trimMethod"`   // trim: collapse | punch
+	TrimArchive   *bool         `yaml:"trimArchive"`  // trim: copy dropped lines to an archive
+	Reopen        *ReopenConfig `yaml:"reopen"`       // rename: tell writers to reopen
+	Hooks         *HooksConfig  `yaml:"hooks"`
+	Codec         string        `yaml:"codec"`         // gzip | zstd | xz | lz4
+	CompressLevel int           `yaml:"compressLevel"` // 0 = codec default
+	Tiers         []ArchiveTier `yaml:"tiers"`
+	ArchiveName   string        `yaml:"archiveName"` // numeric | shift | template, e.g. {base}-{yyyyMMdd-HHmmss}{ext}
+	ArchiveDir    string        `yaml:"archiveDir"`  // mirrors <ns>/<pod>/... when set; may be another volume
+}
+
+// ArchiveTier re-compresses archives once they are older than After, e.g.
+// lz4 right after rotation and zstd at level 19 after a day.
+type ArchiveTier struct {
+	After time.Duration `yaml:"after"`
+	Codec string        `yaml:"codec"`
+	Level int           `yaml:"level"`
+}
+
+// ReopenConfig signals the processes writing to a file after it is renamed,
+// so they reopen the new one. Without PIDFile the writers are found in /proc.
+type ReopenConfig struct {
+	Signal  string        `yaml:"signal"`  // HUP (default), USR1 or USR2
+	PIDFile string        `yaml:"pidFile"` // optional
+	Verify  time.Duration `yaml:"verify"`  // how long to wait for the new file to grow; default 30s
+}
+
+// HooksConfig runs commands around rotation, like logrotate's scripts. Each
+// command is an argv list, run without a shell.
+type HooksConfig struct {
+	PreRotate  []string      `yaml:"prerotate"`  // a non-zero exit vetoes the rotation
+	PostRotate []string      `yaml:"postrotate"` // after each rotation
+	LastAction []string      `yaml:"lastaction"` // once per scan cycle that rotated files
+	Timeout    time.Duration `yaml:"timeout"`    // per command; default 30s
 }
 
 type BudgetConfig struct {
 	PerNamespaceBytes ByteSize `yaml:"perNamespaceBytes"`
 }
 
+// EncryptionConfig encrypts archives with AES-256-GCM after compression.
+// KeyFile holds a 32-byte key (raw, hex or base64). KeyID is written into
+// each archive and defaults to the key file's name, so older keys can stay
+// mounted beside the current one under their own IDs for decryption.
+type EncryptionConfig struct {
+	KeyFile string `yaml:"keyFile"` // empty disables encryption
+	KeyID   string `yaml:"keyId"`
+}
+
 type Defaults struct {
-	Discovery DiscoveryConfig `yaml:"discovery"`
-	Policy    PolicyConfig    `yaml:"policy"`
-	Budgets   BudgetConfig    `yaml:"budgets"`
+	Discovery  DiscoveryConfig  `yaml:"discovery"`
+	Policy     PolicyConfig     `yaml:"policy"`
+	Budgets    BudgetConfig     `yaml:"budgets"`
+	Encryption EncryptionConfig `yaml:"encryption"`
 }
 
 type NamespaceOverride struct {
-	Policy    *PolicyConfig    `yaml:"policy"`
-	Discovery *DiscoveryConfig `yaml:"discovery"`
-	Budgets   *BudgetConfig    `yaml:"budgets"`
+	Policy     *PolicyConfig     `yaml:"policy"`
+	Discovery  *DiscoveryConfig  `yaml:"discovery"`
+	Budgets    *BudgetConfig     `yaml:"budgets"`
+	Encryption *EncryptionConfig `yaml:"encryption"`
 }
 
 type PathOverride struct {
@@ -52,9 +122,134 @@ type Overrides struct {
 	Paths      []PathOverride               `yaml:"paths"`
 }
 
+// StateConfig locates the rotation journal. SnapshotEvery is the number of
+// appended records after which the log is compacted into a new snapshot.
+type StateConfig struct {
+	Path          string `yaml:"path"`
+	SnapshotEvery int    `yaml:"snapshotEvery"`
+}
+
+// CompressionConfig sizes the compression worker pool. QueueSize bounds how
+// many due jobs are handed to workers at once; the rest wait in the journal.
+type CompressionConfig struct {
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queueSize"`
+}
+
+// NotifyConfig sends rotation, compression, retention, purge and error
+// events to webhooks. Events are batched per webhook; a webhook whose queue
+// is full loses new events instead of slowing the scan.
+type NotifyConfig struct {
+	Webhooks      []WebhookConfig `yaml:"webhooks"`
+	QueueSize     int             `yaml:"queueSize"`     // per webhook; default 1000
+	BatchSize     int             `yaml:"batchSize"`     // default 50
+	FlushInterval time.Duration   `yaml:"flushInterval"` // default 5s
+	MaxRetries    int             `yaml:"maxRetries"`    // default 5, with exponential backoff
+	Timeout       time.Duration   `yaml:"timeout"`       // per request; default 10s
+}
+
+type WebhookConfig struct {
+	Name    string            `yaml:"name"` // metric label; defaults to the URL's host
+	URL     string            `yaml:"url"`
+	Events  []string          `yaml:"events"` // empty means all
+	Headers map[string]string `yaml:"headers"`
+}
+
+// ShipConfig uploads compressed archives to an S3-compatible bucket. While
+// it is enabled, retention and budget purging delete only archives that
+// were uploaded, or unshipped ones older than Grace.
+type ShipConfig struct {
+	Endpoint        string        `yaml:"endpoint"` // e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000
+	Bucket          string        `yaml:"bucket"`
+	Region          string        `yaml:"region"` // default us-east-1
+	Prefix          string        `yaml:"prefix"`
+	AccessKeyID     string        `yaml:"accessKeyId"`     // else AWS_ACCESS_KEY_ID
+	SecretAccessKey string        `yaml:"secretAccessKey"` // else AWS_SECRET_ACCESS_KEY
+	Grace           time.Duration `yaml:"grace"`           // 0: never delete unshipped archives
+	Timeout         time.Duration `yaml:"timeout"`         // per upload; default 5m
+}
+
+// CheckpointConfig makes rotation wait for log shippers. Archives a shipper
+// has not finished reading are not deleted, and copytruncate or trim of a
+// file with unread bytes is deferred, each for at most MaxDefer.
+type CheckpointConfig struct {
+	Sources  []CheckpointSource `yaml:"sources"`
+	MaxDefer time.Duration      `yaml:"maxDefer"` // default 1h; negative: wait until read
+}
+
+type CheckpointSource struct {
+	Format string `yaml:"format"` // fluentd | vector | fluentbit
+	Path   string `yaml:"path"`   // pos file, checkpoints.json or in_tail_files JSON export; globs allowed
+}
+
+// DefaultRoot names the single root made from defaults.discovery when no
+// roots are configured.
+const DefaultRoot = "default"
+
+// RootConfig is one directory tree to discover logs in. Discovery fields
+// left empty are taken from defaults.discovery; Policy and Budgets are
+// merged over defaults.policy and defaults.budgets for the root's files,
+// before namespace and path overrides.
+type RootConfig struct {
+	Name      string          `yaml:"name"` // metric label; required
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	Policy    *PolicyConfig   `yaml:"policy"`
+	Budgets   *BudgetConfig   `yaml:"budgets"`
+}
+
 type Config struct {
-	Defaults  Defaults  `yaml:"defaults"`
-	Overrides Overrides `yaml:"overrides"`
+	Defaults    Defaults          `yaml:"defaults"`
+	Roots       []RootConfig      `yaml:"roots"` // empty: one root, defaults.discovery
+	Overrides   Overrides         `yaml:"overrides"`
+	State       StateConfig       `yaml:"state"`
+	Compression CompressionConfig `yaml:"compression"`
+	Notify      NotifyConfig      `yaml:"notify"`
+	Shipping    ShipConfig        `yaml:"shipping"`
+	Checkpoints CheckpointConfig  `yaml:"checkpoints"`
+}
+
+// DiscoveryRoots returns the configured roots with their discovery
+// settings completed from the defaults, or the single DefaultRoot.
+func (c *Config) DiscoveryRoots() []RootConfig {
+	if len(c.Roots) == 0 {
+		return []RootConfig{{Name: DefaultRoot, Discovery: c.Defaults.Discovery}}
+	}
+	d := c.Defaults.Discovery
+	out := make([]RootConfig, len(c.Roots))
+	for i, r := range c.Roots {
+		if r.Discovery.Include == nil {
+			r.Discovery.Include = d.Include
+		}
+		if r.Discovery.Exclude == nil {
+			r.Discovery.Exclude = d.Exclude
+		}
+		if r.Discovery.MaxDepth == 0 {
+			r.Discovery.MaxDepth = d.MaxDepth
+		}
+		if r.Discovery.Mode == "" {
+			r.Discovery.Mode = d.Mode
+		}
+		if r.Discovery.Layout == "" && r.Discovery.LayoutRegex == "" {
+			r.Discovery.Layout, r.Discovery.LayoutRegex = d.Layout, d.LayoutRegex
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// RootOf returns the root of roots that path lies in, or nil.
+func RootOf(roots []RootConfig, path string) *RootConfig {
+	var best *RootConfig
+	for i, r := range roots {
+		rel, err := filepath.Rel(r.Discovery.Path, path)
+		if err != nil || rel == ".." || strings.HasPrefix(filepath.ToSlash(rel), "../") {
+			continue
+		}
+		if best == nil || len(r.Discovery.Path) > len(best.Discovery.Path) {
+			best = &roots[i]
+		}
+	}
+	return best
 }
 
 func Load(path string) (*Config, error) {
@@ -98,9 +293,34 @@ func setDefaults(c *Config) {
 	if c.Defaults.Policy.DefaultMode == "" {
 		c.Defaults.Policy.DefaultMode = "rename"
 	}
+	if c.Defaults.Policy.Codec == "" {
+		c.Defaults.Policy.Codec = "gzip"
+	}
+	if c.Defaults.Policy.TrimKeep == 0 {
+		c.Defaults.Policy.TrimKeep = 10 * MiB
+	}
 	if c.Defaults.Budgets.PerNamespaceBytes == 0 {
 		c.Defaults.Budgets.PerNamespaceBytes = 10 * GiB
 	}
+	if c.State.Path == "" {
+		c.State.Path = "/var/lib/rotator/state.json"
+	}
+	if c.State.SnapshotEvery == 0 {
+		c.State.SnapshotEvery = 1000
+	}
+	if c.Compression.Workers == 0 {
+		c.Compression.Workers = 2
+	}
+	if c.Compression.QueueSize == 0 {
+		c.Compression.QueueSize = 64
+	}
+	if c.Shipping.Timeout == 0 {
+		c.Shipping.Timeout = 5 * time.Minute
+	}
+	if c.Checkpoints.MaxDefer == 0 {
+		// a busy file always has unread bytes; waiting forever would let it grow without bound
+		c.Checkpoints.MaxDefer = time.Hour
+	}
 }
 
 // ByteSize is a helper to parse human-friendly sizes from YAML
@@ -0,0 +1,275 @@
+// Package crypt encrypts archives at rest with AES-256-GCM.
+//
+// A stream is a header followed by sealed chunks:
+//
+//	"RENC" | version (1) | len(keyID) (1) | keyID | chunk size (4, BE) | nonce prefix (8)
+//
+// Each chunk holds up to chunk size bytes of plaintext and is sealed with
+// the nonce prefix followed by its big-endian index. The header and a byte
+// marking the last chunk are authenticated with every chunk, so reordered,
+// dropped or truncated chunks fail to open.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Ext is appended to the names of encrypted archives.
+const Ext = ".enc"
+
+const (
+	magic     = "RENC"
+	version   = 1
+	chunkSize = 64 << 10
+	prefixLen = 8
+	// KeySize is the length of an AES-256 key.
+	KeySize = 32
+)
+
+var (
+	errFormat = errors.New("not an encrypted archive")
+	// ErrCorrupt is returned when a chunk fails authentication.
+	ErrCorrupt = errors.New("encrypted archive is corrupt or was modified")
+)
+
+// LoadKey reads a key file holding 32 bytes, either raw, hex or base64.
+func LoadKey(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == KeySize {
+		return b, nil
+	}
+	s := strings.TrimSpace(string(b))
+	if k, err := hex.DecodeString(s); err == nil && len(k) == KeySize {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == KeySize {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%s: key must be %d bytes, raw, hex or base64", path, KeySize)
+}
+
+// DirKeys looks key IDs up as file names in dir, the layout of a mounted
+// Kubernetes secret.
+func DirKeys(dir string) func(id string) ([]byte, error) {
+	return func(id string) ([]byte, error) {
+		if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+			return nil, fmt.Errorf("invalid key ID %q", id)
+		}
+		return LoadKey(filepath.Join(dir, id))
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	buf    []byte
+	out    []byte
+	closed bool
+}
+
+// NewWriter returns a writer that encrypts to w with key, recording keyID
+// in the header. Close must be called to seal the last chunk.
+func NewWriter(w io.Writer, keyID string, key []byte) (io.WriteCloser, error) {
+	if len(keyID) == 0 || len(keyID) > 255 {
+		return nil, errors.New("key ID must be 1-255 bytes")
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	h := make([]byte, 0, len(magic)+2+len(keyID)+4+prefixLen)
+	h = append(h, magic...)
+	h = append(h, version, byte(len(keyID)))
+	h = append(h, keyID...)
+	h = binary.BigEndian.AppendUint32(h, chunkSize)
+	prefix := make([]byte, prefixLen)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	h = append(h, prefix...)
+	if _, err := w.Write(h); err != nil {
+		return nil, err
+	}
+	return &writer{w: w, aead: aead, header: h, prefix: prefix, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed encrypter")
+	}
+	n := 0
+	for len(p) > 0 {
+		// a full chunk is sealed only once more data shows it is not the last
+		if len(w.buf) == chunkSize {
+			if err := w.seal(false); err != nil {
+				return n, err
+			}
+		}
+		k := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+k]
+		p = p[k:]
+		n += k
+	}
+	return n, nil
+}
+
+func (w *writer) seal(last bool) error {
+	if w.index == ^uint32(0) {
+		return errors.New("encrypted stream too long")
+	}
+	w.out = w.aead.Seal(w.out[:0], nonce(w.prefix, w.index), w.buf, aad(w.header, last))
+	w.index++
+	w.buf = w.buf[:0]
+	_, err := w.w.Write(w.out)
+	return err
+}
+
+// Close seals the last chunk. It does not close the underlying writer.
+func (w *writer) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.seal(true)
+}
+
+type reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	header []byte
+	prefix []byte
+	index  uint32
+	in     []byte
+	plain  []byte
+	done   bool
+}
+
+// NewReader returns a reader that decrypts r, fetching the key named in
+// the header from keys.
+func NewReader(r io.Reader, keys func(id string) ([]byte, error)) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	h, id, size, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keys(id)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{
+		r:      br,
+		aead:   aead,
+		header: h,
+		prefix: h[len(h)-prefixLen:],
+		in:     make([]byte, size+aead.Overhead()),
+	}, nil
+}
+
+// KeyID returns the ID of the key the stream in r was encrypted with.
+func KeyID(r io.Reader) (string, error) {
+	_, id, _, err := readHeader(bufio.NewReader(r))
+	return id, err
+}
+
+func readHeader(br *bufio.Reader) (header []byte, id string, size int, err error) {
+	fixed := make([]byte, len(magic)+2)
+	if _, err := io.ReadFull(br, fixed); err != nil {
+		return nil, "", 0, errFormat
+	}
+	if string(fixed[:len(magic)]) != magic {
+		return nil, "", 0, errFormat
+	}
+	if fixed[len(magic)] != version {
+		return nil, "", 0, fmt.Errorf("unsupported encryption version %d", fixed[len(magic)])
+	}
+	rest := make([]byte, int(fixed[len(magic)+1])+4+prefixLen)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return nil, "", 0, errFormat
+	}
+	idLen := int(fixed[len(magic)+1])
+	size = int(binary.BigEndian.Uint32(rest[idLen:]))
+	if size <= 0 || size > 16<<20 {
+		return nil, "", 0, errFormat
+	}
+	return append(fixed, rest...), string(rest[:idLen]), size, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *reader) next() error {
+	n, err := io.ReadFull(r.r, r.in)
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, perr := r.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+	plain, err := r.aead.Open(r.in[:0], nonce(r.prefix, r.index), r.in[:n], aad(r.header, last))
+	if err != nil {
+		return ErrCorrupt
+	}
+	r.index++
+	r.plain = plain
+	r.done = last
+	return nil
+}
+
+func nonce(prefix []byte, index uint32) []byte {
+	return binary.BigEndian.AppendUint32(bytes.Clone(prefix), index)
+}
+
+func aad(header []byte, last bool) []byte {
+	flag := byte(0)
+	if last {
+		flag = 1
+	}
+	return append(bytes.Clone(header), flag)
+}
@@ -1,92 +1,151 @@
 package discover
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
 )
 
 type FileInfo struct {
 	Path      string
+	Root      string // name of the discovery root
 	Namespace string
 	Pod       string
+	Container string // empty unless the layout captures it
+	Group     string // budget and metric key; the namespace unless in host mode
 	Size      int64
 	ModTimeMs int64
+	Dev       uint64
+	Inode     uint64
 }
 
 type Engine struct {
-	base      config.DiscoveryConfig
+	roots     []*root
 	overrides config.Overrides
+	workers   int
+
+	mu   sync.Mutex
+	dirs map[string]*dirListing // listings of the last completed scan
+}
+
+// root is one discovery root and its compiled layout.
+type root struct {
+	name   string
+	dc     config.DiscoveryConfig
+	layout *Layout // nil if the configured layout is invalid
 }
 
+// New returns a discovery engine for a single root. A layout that does not
+// parse discovers nothing; engine.New reports the error.
 func New(base config.DiscoveryConfig, ov config.Overrides) *Engine {
-	return &Engine{base: base, overrides: ov}
+	return NewRoots([]config.RootConfig{{Name: config.DefaultRoot, Discovery: base}}, ov, base.ScanWorkers)
 }
 
-func (e *Engine) Scan() []FileInfo {
-	var out []FileInfo
-	root := e.base.Path
-	_ = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
-		if err != nil {
-			return nil
-		}
-		if d.IsDir() {
-			if depthExceeds(root, path, e.base.MaxDepth) {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-		// reject symlinks and ensure within root
-		if d.Type()&os.ModeSymlink != 0 {
-			return nil
-		}
-		if !isWithinRoot(root, path) {
-			return nil
-		}
+// NewRoots returns a discovery engine for several roots, as returned by
+// config.DiscoveryRoots. workers bounds how many namespace subtrees are
+// walked at once, across all roots.
+func NewRoots(roots []config.RootConfig, ov config.Overrides, workers int) *Engine {
+	e := &Engine{overrides: ov, workers: workers, dirs: map[string]*dirListing{}}
+	for _, rc := range roots {
+		layout, _ := ParseLayout(rc.Discovery)
+		e.roots = append(e.roots, &root{name: rc.Name, dc: rc.Discovery, layout: layout})
+	}
+	return e
+}
 
-		rel := filepath.ToSlash(path)
-		if !matchesAny(rel, e.base.Include) || matchesAny(rel, e.base.Exclude) {
-			return nil
-		}
-		// infer namespace and pod from /pang/logs/<ns>/<pod>/...
-		ns, pod := inferNSPod(root, path)
-		if ns == "" || pod == "" {
-			return nil
-		}
-		// apply namespace/path discovery overrides if present
-		if !e.allowedByOverrides(ns, rel) {
-			return nil
-		}
-		info, statErr := d.Info()
-		if statErr != nil {
-			return nil
+// Roots returns the names of the discovery roots.
+func (e *Engine) Roots() []string {
+	names := make([]string, len(e.roots))
+	for i, r := range e.roots {
+		names[i] = r.name
+	}
+	return names
+}
+
+// rootOf returns the root path lies in, the deepest if roots nest.
+func (e *Engine) rootOf(path string) *root {
+	var best *root
+	for _, r := range e.roots {
+		if isWithinRoot(r.dc.Path, path) && (best == nil || len(r.dc.Path) > len(best.dc.Path)) {
+			best = r
 		}
-		out = append(out, FileInfo{
-			Path:      path,
-			Namespace: ns,
-			Pod:       pod,
-			Size:      info.Size(),
-			ModTimeMs: info.ModTime().UnixMilli(),
-		})
-		return nil
-	})
+	}
+	return best
+}
+
+// Scan returns every file discovery would report. Walk streams the same
+// files without collecting them.
+func (e *Engine) Scan() []FileInfo {
+	var out []FileInfo
+	e.Walk(context.Background(), func(f FileInfo) { out = append(out, f) })
 	return out
 }
 
-func (e *Engine) allowedByOverrides(namespace, rel string) bool {
-	// Namespace-level discovery include/exclude
-	if nsOv, ok := e.overrides.Namespaces[namespace]; ok {
-		if nsOv.Discovery != nil {
-			dc := nsOv.Discovery
-			if len(dc.Include) > 0 && !matchesAny(rel, dc.Include) {
-				return false
-			}
-			if len(dc.Exclude) > 0 && matchesAny(rel, dc.Exclude) {
-				return false
-			}
+// Stat returns the FileInfo of a single path if discovery would report it.
+func (e *Engine) Stat(path string) (FileInfo, bool) {
+	r := e.rootOf(path)
+	if r == nil || depthExceeds(r.dc.Path, filepath.Dir(path), r.dc.MaxDepth) {
+		return FileInfo{}, false
+	}
+	c, ok := e.accept(r, path)
+	if !ok {
+		return FileInfo{}, false
+	}
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return FileInfo{}, false
+	}
+	dev, ino := util.FileID(info)
+	return FileInfo{
+		Path:      path,
+		Root:      r.name,
+		Namespace: c.Namespace,
+		Pod:       c.Pod,
+		Container: c.Container,
+		Group:     c.Group,
+		Size:      info.Size(),
+		ModTimeMs: info.ModTime().UnixMilli(),
+		Dev:       dev,
+		Inode:     ino,
+	}, true
+}
+
+// accept applies the root's include/exclude and layout rules and the
+// overrides to path and returns what the layout captured from it.
+func (e *Engine) accept(r *root, path string) (Captures, bool) {
+	// ensure within root
+	if !isWithinRoot(r.dc.Path, path) || r.layout == nil {
+		return Captures{}, false
+	}
+
+	rel := filepath.ToSlash(path)
+	if !matchesAny(rel, r.dc.Include) || matchesAny(rel, r.dc.Exclude) {
+		return Captures{}, false
+	}
+	c, ok := r.layout.Infer(r.dc.Path, path)
+	if !ok {
+		return Captures{}, false
+	}
+	// apply namespace/path discovery overrides if present
+	if !e.allowedByOverrides(r, c.Namespace, path) {
+		return Captures{}, false
+	}
+	return c, true
+}
+
+func (e *Engine) allowedByOverrides(r *root, namespace, path string) bool {
+	rel := filepath.ToSlash(path)
+	// Namespace-level discovery include/exclude; host roots are keyed by
+	// path overrides alone
+	if nsOv, ok := e.overrides.Namespaces[namespace]; ok && r.dc.Mode != config.ModeHost {
+		if nsOv.Discovery != nil && !allowedBy(nsOv.Discovery, path, rel) {
+			return false
 		}
 	}
 	// Path-level discovery include/exclude (first matching path override)
@@ -95,11 +154,7 @@ func (e *Engine) allowedByOverrides(namespace, rel string) bool {
 			continue
 		}
 		if ok, _ := doublestar.PathMatch(p.Match, rel); ok {
-			dc := p.Discovery
-			if len(dc.Include) > 0 && !matchesAny(rel, dc.Include) {
-				return false
-			}
-			if len(dc.Exclude) > 0 && matchesAny(rel, dc.Exclude) {
+			if !allowedBy(p.Discovery, path, rel) {
 				return false
 			}
 			break
@@ -108,6 +163,21 @@ func (e *Engine) allowedByOverrides(namespace, rel string) bool {
 	return true
 }
 
+// allowedBy applies an override's discovery rules. Its Path, if set,
+// confines the override's files to that directory.
+func allowedBy(dc *config.DiscoveryConfig, path, rel string) bool {
+	if dc.Path != "" && !isWithinRoot(dc.Path, path) {
+		return false
+	}
+	if len(dc.Include) > 0 && !matchesAny(rel, dc.Include) {
+		return false
+	}
+	if len(dc.Exclude) > 0 && matchesAny(rel, dc.Exclude) {
+		return false
+	}
+	return true
+}
+
 func depthExceeds(root, path string, max int) bool {
 	if max <= 0 {
 		return false
@@ -135,18 +205,6 @@ func matchesAny(path string, patterns []string) bool {
 	return false
 }
 
-func inferNSPod(root, full string) (string, string) {
-	rel, err := filepath.Rel(root, full)
-	if err != nil {
-		return "", ""
-	}
-	parts := strings.Split(filepath.ToSlash(rel), "/")
-	if len(parts) < 3 {
-		return "", ""
-	}
-	return parts[0], parts[1]
-}
-
 func isWithinRoot(root, p string) bool {
 	rel, err := filepath.Rel(root, p)
 	if err != nil {
@@ -0,0 +1,210 @@
+package discover
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+)
+
+// DefaultLayout is the layout of the /pang/logs mount:
+// <root>/<namespace>/<pod>/... at any depth below the pod.
+const DefaultLayout = "{namespace}/{pod}/**"
+
+// DefaultHostLayout matches every file; host mode needs no captures.
+const DefaultHostLayout = "**"
+
+// Layout infers who a file belongs to from its path below the discovery
+// root. It is written either as a template or as a regular expression with
+// named captures.
+//
+// Template syntax, matched against the whole relative path:
+//
+//	{namespace} {pod} {container}  captured; one path segment or part of one
+//	{group}                        captured; the budget and metric key in host mode
+//	{anything}                     matched like a capture but ignored ({uid}, {id})
+//	*                              any part of one path segment
+//	**                             anything, across segments
+//
+// For example "{namespace}_{pod}_{uid}/{container}/*.log" for kubelet's
+// /var/log/pods. Files the layout does not match are not discovered.
+type Layout struct {
+	re                        *regexp.Regexp
+	ns, pod, container, group int  // submatch indexes, 0 if not captured
+	groupFirst                bool // the group is the whole first segment
+	host                      bool // namespace and pod are optional
+}
+
+// Captures are the values a Layout read from a path. Group is what budgets
+// and metrics are kept by: the namespace, or in host mode the {group}
+// capture, else the namespace if captured, else the first path element
+// below the root without its extension (nginx for nginx/access.log, syslog
+// for syslog).
+type Captures struct {
+	Namespace string
+	Pod       string
+	Container string
+	Group     string
+}
+
+// ParseLayout compiles the layout of dc: LayoutRegex if set, else Layout,
+// else the default layout of its mode.
+func ParseLayout(dc config.DiscoveryConfig) (*Layout, error) {
+	var (
+		src        string
+		groupFirst bool
+		host       bool
+	)
+	switch dc.Mode {
+	case "", config.ModeKubernetes:
+	case config.ModeHost:
+		host = true
+	default:
+		return nil, fmt.Errorf("discovery: unknown mode %q (want %s or %s)", dc.Mode, config.ModeKubernetes, config.ModeHost)
+	}
+	switch {
+	case dc.Layout != "" && dc.LayoutRegex != "":
+		return nil, errors.New("discovery: set layout or layoutRegex, not both")
+	case dc.LayoutRegex != "":
+		src = dc.LayoutRegex
+	default:
+		tmpl := dc.Layout
+		if tmpl == "" {
+			tmpl = DefaultLayout
+			if host {
+				tmpl = DefaultHostLayout
+			}
+		}
+		var err error
+		if src, err = layoutRegexp(tmpl); err != nil {
+			return nil, err
+		}
+		nsFirst := strings.HasPrefix(tmpl, "{namespace}/")
+		if host {
+			groupFirst = strings.HasPrefix(tmpl, "{group}/") || nsFirst && !strings.Contains(tmpl, "{group}")
+		} else {
+			groupFirst = nsFirst
+		}
+	}
+	re, err := regexp.Compile("^(?:" + src + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("discovery layout: %w", err)
+	}
+	l := &Layout{
+		re:         re,
+		ns:         re.SubexpIndex("namespace"),
+		pod:        re.SubexpIndex("pod"),
+		container:  re.SubexpIndex("container"),
+		group:      re.SubexpIndex("group"),
+		groupFirst: groupFirst,
+		host:       host,
+	}
+	if !host && (l.ns <= 0 || l.pod <= 0) {
+		return nil, fmt.Errorf("discovery layout %q must capture namespace and pod", src)
+	}
+	return l, nil
+}
+
+// layoutRegexp translates a layout template to a regular expression.
+func layoutRegexp(tmpl string) (string, error) {
+	var b strings.Builder
+	seen := map[string]bool{}
+	for rest := tmpl; rest != ""; {
+		switch {
+		case strings.HasPrefix(rest, "**"):
+			b.WriteString(".*")
+			rest = rest[2:]
+		case rest[0] == '*':
+			b.WriteString("[^/]*")
+			rest = rest[1:]
+		case rest[0] == '{':
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				return "", fmt.Errorf("discovery layout %q: unclosed '{'", tmpl)
+			}
+			field := rest[1:end]
+			rest = rest[end+1:]
+			if field == "" || strings.Trim(field, "abcdefghijklmnopqrstuvwxyz_") != "" {
+				return "", fmt.Errorf("discovery layout %q: invalid placeholder {%s}", tmpl, field)
+			}
+			switch field {
+			case "namespace", "pod", "container", "group":
+				if seen[field] {
+					return "", fmt.Errorf("discovery layout %q: {%s} appears twice", tmpl, field)
+				}
+				seen[field] = true
+				b.WriteString("(?P<" + field + ">[^/]+)")
+			default:
+				b.WriteString("[^/]+")
+			}
+		default:
+			i := strings.IndexAny(rest, "*{")
+			if i < 0 {
+				i = len(rest)
+			}
+			b.WriteString(regexp.QuoteMeta(rest[:i]))
+			rest = rest[i:]
+		}
+	}
+	return b.String(), nil
+}
+
+// Infer returns the captures of path, a file below root.
+func (l *Layout) Infer(root, path string) (Captures, bool) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return Captures{}, false
+	}
+	rel = filepath.ToSlash(rel)
+	m := l.re.FindStringSubmatch(rel)
+	if m == nil {
+		return Captures{}, false
+	}
+	var c Captures
+	for _, f := range []struct {
+		i   int
+		dst *string
+	}{{l.ns, &c.Namespace}, {l.pod, &c.Pod}, {l.container, &c.Container}, {l.group, &c.Group}} {
+		if f.i > 0 {
+			*f.dst = m[f.i]
+		}
+	}
+	if !l.host {
+		if c.Namespace == "" || c.Pod == "" {
+			return Captures{}, false
+		}
+		c.Group = c.Namespace
+		return c, true
+	}
+	if c.Group == "" {
+		c.Group = c.Namespace
+	}
+	if c.Group == "" {
+		c.Group = hostGroup(rel)
+	}
+	return c, true
+}
+
+// hostGroup is the default group of a file in host mode.
+func hostGroup(rel string) string {
+	first, _, nested := strings.Cut(rel, "/")
+	if nested {
+		return first
+	}
+	if i := strings.IndexByte(first[1:], '.'); i >= 0 {
+		return first[:i+1]
+	}
+	return first
+}
+
+// GroupDir returns the directory below the root that holds all of a
+// group's files, or "" if the layout does not keep groups apart.
+func (l *Layout) GroupDir(group string) string {
+	if !l.groupFirst {
+		return ""
+	}
+	return group
+}
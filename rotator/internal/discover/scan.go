@@ -0,0 +1,250 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultScanWorkers bounds how many namespace subtrees are walked at once.
+	defaultScanWorkers = 4
+	// readDirBatch is how many entries are read from a directory per call.
+	readDirBatch = 1024
+	// listingSettle is how old a directory's mtime must be before its listing
+	// is reused: a change within the same timestamp tick as the listing would
+	// otherwise go unnoticed.
+	listingSettle = time.Second
+)
+
+// ScanStats summarizes one Walk.
+type ScanStats struct {
+	Files      int            // files reported
+	ByRoot     map[string]int // files reported per root
+	Dirs       int            // directories walked
+	CachedDirs int            // directories whose listing was reused
+	Duration   time.Duration
+}
+
+// dirListing is what a directory held when last read, with the include,
+// exclude and override rules already applied to its files.
+type dirListing struct {
+	mtime   time.Time
+	entries []dirEntry
+}
+
+type dirEntry struct {
+	name string
+	dir  bool
+	keep bool     // a file discovery reports
+	of   Captures // what the layout read from its path, if kept
+}
+
+// fileStat is the part of a file's metadata discovery reports.
+type fileStat struct {
+	regular   bool
+	size      int64
+	modTimeMs int64
+	dev, ino  uint64
+}
+
+// Walk calls fn for every file discovery would report. Namespace subtrees
+// of all roots are walked in parallel, but fn is called from the calling goroutine only.
+// Directories whose mtime has not changed since the last completed walk are
+// not read again; their files are still stat'ed, as writes do not touch the
+// directory. Walk stops early when ctx is done.
+func (e *Engine) Walk(ctx context.Context, fn func(FileInfo)) ScanStats {
+	start := time.Now()
+	e.mu.Lock()
+	prev := e.dirs
+	e.mu.Unlock()
+
+	workers := e.workers
+	if workers <= 0 {
+		workers = defaultScanWorkers
+	}
+	s := &scan{e: e, ctx: ctx, prev: prev, next: map[string]*dirListing{}, out: make(chan []FileInfo, workers)}
+	go func() {
+		defer close(s.out)
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for _, r := range e.roots {
+			top := &walker{s: s, r: r}
+			for _, d := range top.dir(r.dc.Path, 0) {
+				if ctx.Err() != nil {
+					break
+				}
+				sem <- struct{}{}
+				wg.Add(1)
+				go func(r *root, d string) {
+					defer func() { <-sem; wg.Done() }()
+					w := &walker{s: s, r: r}
+					w.tree(d, 1)
+					s.merge(w)
+				}(r, d)
+			}
+			s.merge(top)
+		}
+		wg.Wait()
+	}()
+
+	st := ScanStats{ByRoot: map[string]int{}}
+	for _, r := range e.roots {
+		st.ByRoot[r.name] = 0
+	}
+	for batch := range s.out {
+		for _, f := range batch {
+			fn(f)
+		}
+		// a batch is one directory's files
+		st.Files += len(batch)
+		st.ByRoot[batch[0].Root] += len(batch)
+	}
+	st.Dirs, st.CachedDirs = s.dirs, s.cached
+	st.Duration = time.Since(start)
+	if ctx.Err() == nil {
+		e.mu.Lock()
+		e.dirs = s.next
+		e.mu.Unlock()
+	}
+	return st
+}
+
+// scan is the state shared by the walkers of one Walk.
+type scan struct {
+	e    *Engine
+	ctx  context.Context
+	prev map[string]*dirListing // read only
+	out  chan []FileInfo
+
+	mu           sync.Mutex
+	next         map[string]*dirListing
+	dirs, cached int
+}
+
+func (s *scan) merge(w *walker) {
+	s.mu.Lock()
+	s.dirs += w.dirs
+	s.cached += w.cached
+	s.mu.Unlock()
+}
+
+func (s *scan) remember(path string, l *dirListing) {
+	s.mu.Lock()
+	s.next[path] = l
+	s.mu.Unlock()
+}
+
+// walker walks one subtree of a root depth-first.
+type walker struct {
+	s            *scan
+	r            *root
+	dirs, cached int
+}
+
+func (w *walker) tree(path string, depth int) {
+	if w.s.ctx.Err() != nil {
+		return
+	}
+	for _, sub := range w.dir(path, depth) {
+		w.tree(sub, depth+1)
+	}
+}
+
+// dir reports the files of the directory at path and returns its
+// subdirectories that are within MaxDepth.
+func (w *walker) dir(path string, depth int) []string {
+	if max := w.r.dc.MaxDepth; max > 0 && depth > max {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.IsDir() {
+		return nil
+	}
+	w.dirs++
+
+	l := w.s.prev[path]
+	if l != nil && l.mtime.Equal(fi.ModTime()) {
+		w.cached++
+	} else {
+		l = w.list(f, path, fi.ModTime())
+	}
+	w.s.remember(path, l)
+
+	var (
+		subdirs []string
+		batch   []FileInfo
+	)
+	for _, ent := range l.entries {
+		if ent.dir {
+			subdirs = append(subdirs, filepath.Join(path, ent.name))
+			continue
+		}
+		if !ent.keep {
+			continue
+		}
+		st, err := statAt(f, ent.name)
+		if err != nil || !st.regular {
+			continue
+		}
+		batch = append(batch, FileInfo{
+			Path:      filepath.Join(path, ent.name),
+			Root:      w.r.name,
+			Namespace: ent.of.Namespace,
+			Pod:       ent.of.Pod,
+			Container: ent.of.Container,
+			Group:     ent.of.Group,
+			Size:      st.size,
+			ModTimeMs: st.modTimeMs,
+			Dev:       st.dev,
+			Inode:     st.ino,
+		})
+	}
+	if len(batch) > 0 {
+		select {
+		case w.s.out <- batch:
+		case <-w.s.ctx.Done():
+		}
+	}
+	return subdirs
+}
+
+// list reads the directory in batches and applies the discovery rules to
+// its files. The listing is only reused by the next walk once the directory
+// has settled.
+func (w *walker) list(f *os.File, path string, mtime time.Time) *dirListing {
+	l := &dirListing{}
+	for {
+		ents, err := f.ReadDir(readDirBatch)
+		for _, d := range ents {
+			switch {
+			case d.IsDir():
+				l.entries = append(l.entries, dirEntry{name: d.Name(), dir: true})
+			case d.Type().IsRegular():
+				// symlinks and special files are never reported
+				c, keep := w.s.e.accept(w.r, filepath.Join(path, d.Name()))
+				l.entries = append(l.entries, dirEntry{name: d.Name(), keep: keep, of: c})
+			}
+		}
+		if errors.Is(err, io.EOF) || (err == nil && len(ents) == 0) {
+			break
+		}
+		if err != nil {
+			// a partial listing is used once but never reused
+			return l
+		}
+	}
+	if time.Since(mtime) >= listingSettle {
+		l.mtime = mtime
+	}
+	return l
+}
@@ -0,0 +1,23 @@
+package discover
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// statAt stats name relative to the open directory, sparing the kernel a
+// path lookup from the root for every file.
+func statAt(dir *os.File, name string) (fileStat, error) {
+	var st unix.Stat_t
+	if err := unix.Fstatat(int(dir.Fd()), name, &st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fileStat{}, err
+	}
+	return fileStat{
+		regular:   st.Mode&unix.S_IFMT == unix.S_IFREG,
+		size:      st.Size,
+		modTimeMs: int64(st.Mtim.Sec)*1000 + int64(st.Mtim.Nsec)/1e6,
+		dev:       uint64(st.Dev),
+		ino:       uint64(st.Ino),
+	}, nil
+}
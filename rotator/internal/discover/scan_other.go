@@ -0,0 +1,25 @@
+//go:build !linux
+
+package discover
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func statAt(dir *os.File, name string) (fileStat, error) {
+	fi, err := os.Lstat(filepath.Join(dir.Name(), name))
+	if err != nil {
+		return fileStat{}, err
+	}
+	dev, ino := util.FileID(fi)
+	return fileStat{
+		regular:   fi.Mode().IsRegular(),
+		size:      fi.Size(),
+		modTimeMs: fi.ModTime().UnixMilli(),
+		dev:       dev,
+		ino:       ino,
+	}, nil
+}
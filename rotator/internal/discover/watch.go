@@ -0,0 +1,139 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// watchSettle is how long changes are collected before they are reported,
+// so a busy writer produces one re-evaluation per window, not one per write.
+const watchSettle = 250 * time.Millisecond
+
+// resyncBatch bounds the batches a full walk after dropped events is
+// reported in.
+const resyncBatch = 1024
+
+// ErrWatchUnsupported is returned by Watch where the platform has no
+// file event API; callers fall back to periodic scans.
+var ErrWatchUnsupported = errors.New("file events are not supported on this platform")
+
+// Watch reports files under the discovery roots as they are created or
+// written, in batches; a file written throughout is reported about once per
+// watchSettle. New directories are watched as they appear, so pods
+// started later are covered. When the kernel drops events a full Walk
+// follows, streamed in batches of at most resyncBatch files. The channel is
+// closed when ctx is done.
+func (e *Engine) Watch(ctx context.Context) (<-chan []FileInfo, error) {
+	p := &pending{paths: map[string]bool{}, wake: make(chan struct{}, 1)}
+	if err := e.watch(ctx, p); err != nil {
+		return nil, err
+	}
+	out := make(chan []FileInfo)
+	send := func(batch []FileInfo) bool {
+		if len(batch) == 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case out <- batch:
+			return true
+		}
+	}
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.wake:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchSettle):
+			}
+			paths, resync := p.take()
+			if p.rearm != nil {
+				p.rearm(resync)
+			}
+			var batch []FileInfo
+			if resync {
+				// the walk covers the paths of this window too. send only
+				// fails once ctx is done, which stops the walk as well.
+				sent := true
+				e.Walk(ctx, func(f FileInfo) {
+					if !sent {
+						return
+					}
+					if batch = append(batch, f); len(batch) == resyncBatch {
+						sent = send(batch)
+						batch = nil
+					}
+				})
+				if !sent {
+					return
+				}
+			} else {
+				for _, path := range paths {
+					if f, ok := e.Stat(path); ok {
+						batch = append(batch, f)
+					}
+				}
+			}
+			if !send(batch) {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// pending collects changed paths between batches. rearm, if the platform
+// sets it, is called after each take so reported files are watched for
+// their next write; all is set when events were lost.
+type pending struct {
+	mu     sync.Mutex
+	paths  map[string]bool
+	resync bool
+	wake   chan struct{}
+	rearm  func(all bool)
+}
+
+func (p *pending) add(path string) {
+	p.mu.Lock()
+	p.paths[path] = true
+	p.mu.Unlock()
+	p.signal()
+}
+
+// overflow asks for a full scan because events were lost.
+func (p *pending) overflow() {
+	p.mu.Lock()
+	p.resync = true
+	p.mu.Unlock()
+	p.signal()
+}
+
+func (p *pending) signal() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *pending) take() ([]string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	paths := make([]string, 0, len(p.paths))
+	for path := range p.paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	resync := p.resync
+	p.paths, p.resync = map[string]bool{}, false
+	return paths, resync
+}
@@ -0,0 +1,229 @@
+package discover
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMask selects directory changes; directories removed from the tree
+// drop their watch by themselves (IN_IGNORED).
+const watchMask = unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM |
+	unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+// fileMask watches a discovered file for its next write only. Watching
+// writes on the directories queues one event per write, which overflows
+// the kernel queue on busy nodes; a one-shot watch per file costs at most
+// one event per settle window, as the file is re-armed when it is reported.
+const fileMask = unix.IN_MODIFY | unix.IN_ONESHOT | unix.IN_DONT_FOLLOW
+
+// inotify watches every directory of the tree; inotify is not recursive.
+type inotify struct {
+	e *Engine
+	p *pending
+	f *os.File
+
+	mu    sync.Mutex
+	dirs  map[int]string  // watch descriptor -> directory
+	files map[int]string  // one-shot write watch -> file
+	fired map[string]bool // files written since the last batch, to re-arm
+}
+
+func (e *Engine) watch(ctx context.Context, p *pending) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// non-blocking, so reads go through the runtime poller and Close
+	// unblocks them
+	w := &inotify{e: e, p: p, f: os.NewFile(uintptr(fd), "inotify"), dirs: map[int]string{}, files: map[int]string{}, fired: map[string]bool{}}
+	p.rearm = w.rearm
+	for _, r := range e.roots {
+		if err := w.addTree(r.dc.Path, false); err != nil {
+			_ = w.f.Close()
+			return err
+		}
+	}
+	go func() {
+		<-ctx.Done()
+		_ = w.f.Close()
+	}()
+	go w.read()
+	return nil
+}
+
+// addTree watches dir and the directories below it, down to its root's
+// MaxDepth, and arms the files in them. With report set, files already there
+// are reported as well: they may have been written before the watch was in
+// place. It stops at the first directory that cannot be watched, e.g. when
+// fs.inotify.max_user_watches is reached.
+func (w *inotify) addTree(dir string, report bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				return err
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			if d.Type().IsRegular() && w.arm(path) && report {
+				w.p.add(path)
+			}
+			return nil
+		}
+		if r := w.e.rootOf(path); r == nil || depthExceeds(r.dc.Path, path, r.dc.MaxDepth) {
+			return filepath.SkipDir
+		}
+		wd, err := w.addWatch(path, watchMask)
+		if err != nil {
+			return &fs.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		w.mu.Lock()
+		w.dirs[wd] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// addWatch and rmWatch go through the file's raw connection so the
+// descriptor cannot be closed and reused under them.
+func (w *inotify) addWatch(path string, mask uint32) (int, error) {
+	rc, err := w.f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var wd int
+	var werr error
+	if err := rc.Control(func(fd uintptr) {
+		wd, werr = unix.InotifyAddWatch(int(fd), path, mask)
+	}); err != nil {
+		return 0, err
+	}
+	return wd, werr
+}
+
+// arm watches path for its next write if discovery would report it. A file
+// that cannot be watched is still covered by the reconciliation scan.
+func (w *inotify) arm(path string) bool {
+	if _, ok := w.e.Stat(path); !ok {
+		return false
+	}
+	if wd, err := w.addWatch(path, fileMask); err == nil {
+		w.mu.Lock()
+		w.files[wd] = path
+		w.mu.Unlock()
+	}
+	return true
+}
+
+// rearm watches the files reported since the last call for their next
+// write. After an overflow the firing of one-shot watches may have been
+// lost with the events, so every file is armed again; directories whose
+// creation was lost are picked up on the way.
+func (w *inotify) rearm(all bool) {
+	w.mu.Lock()
+	fired := w.fired
+	w.fired = map[string]bool{}
+	if all {
+		w.files = map[int]string{}
+	}
+	w.mu.Unlock()
+	if all {
+		for _, r := range w.e.roots {
+			_ = w.addTree(r.dc.Path, false)
+		}
+		return
+	}
+	for path := range fired {
+		w.arm(path)
+	}
+}
+
+func (w *inotify) rmWatch(wd int) {
+	if rc, err := w.f.SyscallConn(); err == nil {
+		_ = rc.Control(func(fd uintptr) {
+			_, _ = unix.InotifyRmWatch(int(fd), uint32(wd))
+		})
+	}
+}
+
+func (w *inotify) read() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			wd := int(int32(binary.NativeEndian.Uint32(buf[off:])))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			size := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			off += unix.SizeofInotifyEvent
+			if off+size > n {
+				break
+			}
+			name := string(bytes.TrimRight(buf[off:off+size], "\x00"))
+			off += size
+			w.handle(wd, mask, name)
+		}
+	}
+}
+
+func (w *inotify) handle(wd int, mask uint32, name string) {
+	if mask&unix.IN_Q_OVERFLOW != 0 {
+		w.p.overflow()
+		return
+	}
+	w.mu.Lock()
+	dir, ok := w.dirs[wd]
+	file, written := w.files[wd]
+	written = written && mask&unix.IN_MODIFY != 0
+	if written {
+		w.fired[file] = true
+	}
+	if mask&unix.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		delete(w.files, wd)
+	}
+	w.mu.Unlock()
+	if written {
+		w.p.add(file)
+		return
+	}
+	if !ok || name == "" {
+		return
+	}
+	path := filepath.Join(dir, name)
+	switch {
+	case mask&unix.IN_ISDIR == 0:
+		if mask&unix.IN_MOVED_FROM == 0 && w.arm(path) {
+			w.p.add(path)
+		}
+	case mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		// a new pod directory; watch it and pick up what it already holds.
+		// If it cannot be watched the reconciliation scan still covers it.
+		_ = w.addTree(path, true)
+	case mask&unix.IN_MOVED_FROM != 0:
+		w.forget(path)
+	}
+}
+
+// forget drops the watches of a directory moved out of place and of the
+// directories below it.
+func (w *inotify) forget(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd, d := range w.dirs {
+		if d == dir || strings.HasPrefix(d, dir+string(filepath.Separator)) {
+			w.rmWatch(wd)
+			delete(w.dirs, wd)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package discover
+
+import "context"
+
+func (e *Engine) watch(ctx context.Context, p *pending) error {
+	return ErrWatchUnsupported
+}
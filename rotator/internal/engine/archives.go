@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/notify"
+)
+
+// archiveFile is an archive found on disk and the live file it belongs to.
+type archiveFile struct {
+	path      string
+	base      string
+	namespace string
+	info      fs.FileInfo
+}
+
+// walkArchives calls fn for every archive of a discovered file, next to the
+// live files of a root and under every archiveDir, limited to root r and one
+// namespace unless they are nil and empty. An entry is an archive if the
+// journal knows it, if it matches the naming scheme of its live file's
+// policy, or, for orphans whose live file is gone, if it follows the legacy
+// numeric scheme. The namespace of an archive is the one it was rotated
+// under, or else the group the layout reads from its live file's path.
+func (e *Engine) walkArchives(r *rootState, namespace string, known map[string]ArchiveState, fn func(archiveFile)) {
+	skip := e.archiveRoots()
+	for _, t := range e.archiveTrees() {
+		if r != nil && t.root != r {
+			continue
+		}
+		start := t.dir
+		if namespace != "" {
+			if d := t.root.layout.GroupDir(namespace); d != "" {
+				start = filepath.Join(t.dir, d)
+			}
+		}
+		_ = filepath.WalkDir(start, func(dir string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if dir != t.dir && isRoot(skip, dir) {
+				return filepath.SkipDir
+			}
+			rel, rerr := filepath.Rel(t.dir, dir)
+			if rerr != nil {
+				return nil
+			}
+			entries, rerr := os.ReadDir(dir)
+			if rerr != nil {
+				return nil
+			}
+			liveDir := filepath.Join(t.root.path, rel)
+			for path, base := range e.archivesIn(dir, liveDir, entries, known) {
+				ns, ok := e.groupOf(base)
+				if a, k := known[path]; k && a.Namespace != "" {
+					ns, ok = a.Namespace, true
+				}
+				if !ok || (namespace != "" && ns != namespace) {
+					continue
+				}
+				info, ierr := os.Stat(path)
+				if ierr != nil {
+					continue
+				}
+				fn(archiveFile{path: path, base: base, namespace: ns, info: info})
+			}
+			return nil
+		})
+	}
+}
+
+// archiveTree is a directory tree holding archives of one root's files.
+type archiveTree struct {
+	dir  string
+	root *rootState
+}
+
+// archiveTrees returns each root and, for each root, its part of every
+// archiveDir.
+func (e *Engine) archiveTrees() []archiveTree {
+	var trees []archiveTree
+	for _, r := range e.roots {
+		trees = append(trees, archiveTree{dir: r.path, root: r})
+		for _, d := range archiveDirsOf(e.cfg) {
+			trees = append(trees, archiveTree{dir: filepath.Join(d, r.sub), root: r})
+		}
+	}
+	return trees
+}
+
+// groupOf returns the group the layout of its root reads from a live
+// file's path, whether or not the file still exists. Outside host mode that
+// is the namespace.
+func (e *Engine) groupOf(live string) (string, bool) {
+	r := e.rootFor(live)
+	if r == nil {
+		return "", false
+	}
+	c, ok := r.layout.Infer(r.path, live)
+	return c.Group, ok
+}
+
+func isRoot(roots []string, dir string) bool {
+	for _, r := range roots {
+		if filepath.Clean(r) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// archivesIn maps each archive in dir to its live file in liveDir, which is
+// dir itself unless dir is under an archiveDir.
+func (e *Engine) archivesIn(dir, liveDir string, entries []fs.DirEntry, known map[string]ArchiveState) map[string]string {
+	names := regularNames(entries)
+	lives := names
+	if liveDir != dir {
+		liveEntries, _ := os.ReadDir(liveDir)
+		lives = regularNames(liveEntries)
+	}
+	out := map[string]string{}
+	for _, n := range names {
+		p := filepath.Join(dir, n)
+		if a, ok := known[p]; ok {
+			out[p] = a.Base
+		}
+	}
+	for _, live := range lives {
+		if _, compressed := codecByExt(live); compressed || isEncrypted(live) {
+			continue
+		}
+		livePath := filepath.Join(liveDir, live)
+		if _, ok := out[livePath]; ok {
+			continue
+		}
+		ns, ok := e.groupOf(livePath)
+		if !ok {
+			continue
+		}
+		pol := e.pol.EffectivePolicy(ns, livePath)
+		namer, err := namerFor(pol.ArchiveName)
+		if err != nil {
+			continue
+		}
+		re := namer.matcher(livePath)
+		for _, n := range names {
+			p := filepath.Join(dir, n)
+			if p == livePath {
+				continue
+			}
+			if _, ok := out[p]; !ok && matchesArchive(re, p) {
+				out[p] = livePath
+			}
+		}
+	}
+	for _, n := range names {
+		p := filepath.Join(dir, n)
+		if _, ok := out[p]; ok {
+			continue
+		}
+		if base, ok := archiveBase(trimCodecExt(p)); ok {
+			out[p] = filepath.Join(liveDir, filepath.Base(base))
+		}
+	}
+	return out
+}
+
+func regularNames(entries []fs.DirEntry) []string {
+	var names []string
+	for _, ent := range entries {
+		if ent.Type().IsRegular() && ent.Name() != manifestName {
+			names = append(names, ent.Name())
+		}
+	}
+	return names
+}
+
+// archiveBase returns the live file a legacy numeric archive (file.log.N)
+// was rotated from.
+func archiveBase(path string) (string, bool) {
+	i := strings.LastIndex(path, ".")
+	if i <= 0 || i == len(path)-1 {
+		return "", false
+	}
+	base := path[:i]
+	if !matchesPrefix(path, base) {
+		return "", false
+	}
+	return base, true
+}
+
+// purgeOldestForNamespace removes the oldest archives of the namespace's
+// files in root r until their total size is under the root's budget.
+func (e *Engine) purgeOldestForNamespace(r *rootState, namespace string) {
+	limit := r.limit
+	type item struct {
+		path string
+		base string
+		size int64
+		mod  time.Time
+	}
+	var items []item
+	e.walkArchives(r, namespace, e.jrnl.Archives(), func(a archiveFile) {
+		items = append(items, item{path: a.path, base: a.base, size: a.info.Size(), mod: a.info.ModTime()})
+	})
+	// sort oldest first
+	sort.Slice(items, func(i, j int) bool { return items[i].mod.Before(items[j].mod) })
+	var total int64
+	for _, it := range items {
+		total += it.size
+	}
+	for _, it := range items {
+		if total <= limit {
+			break
+		}
+		if !e.deletable(namespace, "budget", it.path, it.mod) {
+			// not shipped or not read yet; it still counts against the budget
+			continue
+		}
+		if os.Remove(it.path) == nil {
+			e.forgetArchives(it.path)
+			e.ntf.Send(notify.Event{Kind: notify.BudgetPurged, Namespace: namespace, File: it.base, Archive: it.path, Bytes: it.size})
+		}
+		total -= it.size
+	}
+}
@@ -0,0 +1,40 @@
+package engine
+
+import (
+	"os"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/procfs"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// autoMode picks a technique from how the file is held open: rename when no
+// process writes to it, copytruncate when every writer appends. A writer
+// without O_APPEND keeps its offset across a truncate and would leave a
+// sparse file behind, so the rotation is refused.
+func (e *Engine) autoMode(f discover.FileInfo, fi os.FileInfo) (string, bool) {
+	dev, ino := util.FileID(fi)
+	holders, err := procfs.Holders(procfs.Root, dev, ino)
+	if err != nil {
+		e.m.AutoModeRefusals.WithLabelValues(f.Group, "unknown_holders").Inc()
+		e.log.WithError(err).WithField("file", f.Path).Warn("cannot inspect file holders; not rotating")
+		return "", false
+	}
+	tech := "rename"
+	for _, h := range holders {
+		if !h.Write {
+			continue
+		}
+		if !h.Append {
+			e.m.AutoModeRefusals.WithLabelValues(f.Group, "non_append_writer").Inc()
+			e.log.WithFields(map[string]interface{}{
+				"file": f.Path,
+				"pid":  h.PID,
+				"fd":   h.FD,
+			}).Warn("writer does not use O_APPEND; refusing to rotate")
+			return "", false
+		}
+		tech = "copytruncate"
+	}
+	return tech, true
+}
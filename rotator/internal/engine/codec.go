@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/crypt"
+)
+
+// Codec is a compression format for archives. Level 0 means the codec's
+// default; other values are interpreted per codec.
+type Codec interface {
+	Name() string
+	Ext() string // including the leading dot
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	CheckLevel(level int) error
+}
+
+var codecs = map[string]Codec{}
+
+var errUnknownCodec = errors.New("unknown codec")
+
+// registerCodec makes c available to policies by name. Codecs register
+// themselves from init.
+func registerCodec(c Codec) {
+	codecs[c.Name()] = c
+}
+
+// codecFor returns the codec named by a policy; empty means gzip.
+func codecFor(name string) (Codec, error) {
+	if name == "" {
+		name = "gzip"
+	}
+	c, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%w %q (known: %s)", errUnknownCodec, name, strings.Join(codecNames(), ", "))
+	}
+	return c, nil
+}
+
+// codecByExt returns the codec whose extension path carries.
+func codecByExt(path string) (Codec, bool) {
+	for _, c := range codecs {
+		if strings.HasSuffix(path, c.Ext()) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// trimCodecExt strips the encryption extension and a known compression
+// extension from name.
+func trimCodecExt(name string) string {
+	name = strings.TrimSuffix(name, crypt.Ext)
+	if c, ok := codecByExt(name); ok {
+		return strings.TrimSuffix(name, c.Ext())
+	}
+	return name
+}
+
+// levelIn accepts 0 (the codec's default) and lo through hi.
+func levelIn(level, lo, hi int) error {
+	if level != 0 && (level < lo || level > hi) {
+		return fmt.Errorf("level %d out of range (want %d-%d, or 0 for the default)", level, lo, hi)
+	}
+	return nil
+}
+
+func codecNames() []string {
+	names := make([]string, 0, len(codecs))
+	for n := range codecs {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileCodec is implemented by codecs whose format records the original
+// file's name and modification time.
+type fileCodec interface {
+	NewFileWriter(w io.Writer, level int, name string, mod time.Time) (io.WriteCloser, error)
+}
+
+// newFileWriter returns a writer for c, filling in the file name and
+// modification time where the format has room for them.
+func newFileWriter(c Codec, w io.Writer, level int, name string, mod time.Time) (io.WriteCloser, error) {
+	if fc, ok := c.(fileCodec); ok {
+		return fc.NewFileWriter(w, level, name, mod)
+	}
+	return c.NewWriter(w, level)
+}
+
+// compressFile compresses src into src+ext with codec c. The output is
+// written to a temp file, read back and compared with src, given src's
+// mode, owner and times, and renamed into place before src is removed.
+func compressFile(src string, c Codec, level int) (string, error) {
+	dst := src + c.Ext()
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	zw, err := newFileWriter(c, tmp, level, filepath.Base(src), fi.ModTime())
+	if err != nil {
+		return "", err
+	}
+	want := sha256.New()
+	if _, err := io.Copy(zw, io.TeeReader(in, want)); err != nil {
+		_ = zw.Close()
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	if err := verifyEncoded(tmp, c, want.Sum(nil)); err != nil {
+		return "", fmt.Errorf("verify %s: %w", dst, err)
+	}
+	if err := copyOwner(tmp, fi); err != nil {
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := copyTimes(tmp.Name(), fi); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return "", err
+	}
+	if err := os.Remove(src); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+// verifyEncoded decompresses f from the start and checks that the data
+// hashes to want.
+func verifyEncoded(f *os.File, c Codec, want []byte) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	zr, err := c.NewReader(f)
+	if err != nil {
+		return err
+	}
+	got := sha256.New()
+	_, err = io.Copy(got, zr)
+	_ = zr.Close()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got.Sum(nil), want) {
+		return errors.New("checksum mismatch")
+	}
+	return nil
+}
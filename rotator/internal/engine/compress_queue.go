@@ -0,0 +1,271 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/crypt"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/notify"
+)
+
+const (
+	// dispatchInterval bounds how late a due job is handed to a worker.
+	dispatchInterval = time.Second
+	// compressRetryDelay postpones a job whose compression failed.
+	compressRetryDelay = time.Minute
+)
+
+// compressQueue runs compression jobs on a fixed pool of workers. Jobs live
+// in the journal so they survive restarts; only the set of jobs currently
+// handed to a worker is kept in memory.
+type compressQueue struct {
+	e       *Engine
+	workers int
+	ready   chan CompressJob
+	wake    chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func newCompressQueue(e *Engine, workers, size int) *compressQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	if size <= 0 {
+		size = workers
+	}
+	return &compressQueue{
+		e:       e,
+		workers: workers,
+		ready:   make(chan CompressJob, size),
+		wake:    make(chan struct{}, 1),
+		running: map[string]bool{},
+	}
+}
+
+func (q *compressQueue) start(ctx context.Context) {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.rescan()
+		q.sweepTiers()
+		q.dispatch(ctx)
+	}()
+}
+
+// wait blocks until all workers have returned.
+func (q *compressQueue) wait() { q.wg.Wait() }
+
+// add persists job and nudges the dispatcher.
+func (q *compressQueue) add(job CompressJob) error {
+	if err := q.e.jrnl.Enqueue(job); err != nil {
+		return err
+	}
+	q.e.m.CompressQueueDepth.Set(float64(q.e.jrnl.QueueLen()))
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *compressQueue) dispatch(ctx context.Context) {
+	t := time.NewTicker(dispatchInterval)
+	defer t.Stop()
+	tiers := time.NewTicker(tierCheckInterval)
+	defer tiers.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-q.wake:
+		case <-tiers.C:
+			q.sweepTiers()
+		}
+		q.dispatchDue()
+	}
+}
+
+// dispatchDue hands due jobs to workers without blocking; whatever does not
+// fit in the ready channel waits for the next tick.
+func (q *compressQueue) dispatchDue() {
+	now := time.Now()
+	for _, job := range q.e.jrnl.Queued() {
+		if job.Due.After(now) {
+			return
+		}
+		q.mu.Lock()
+		if q.running[job.Path] {
+			q.mu.Unlock()
+			continue
+		}
+		q.running[job.Path] = true
+		q.mu.Unlock()
+		select {
+		case q.ready <- job:
+		default:
+			q.done(job.Path)
+			return
+		}
+	}
+}
+
+// claim marks paths as busy so no worker picks them up, failing if any is
+// already being worked on. The returned func releases them.
+func (q *compressQueue) claim(paths []string) (func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, p := range paths {
+		if q.running[p] {
+			return nil, false
+		}
+	}
+	for _, p := range paths {
+		q.running[p] = true
+	}
+	return func() {
+		for _, p := range paths {
+			q.done(p)
+		}
+	}, true
+}
+
+func (q *compressQueue) done(path string) {
+	q.mu.Lock()
+	delete(q.running, path)
+	q.mu.Unlock()
+}
+
+func (q *compressQueue) work(ctx context.Context) {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-q.ready:
+			q.run(job)
+		}
+	}
+}
+
+func (q *compressQueue) run(job CompressJob) {
+	defer q.done(job.Path)
+	defer func() { q.e.m.CompressQueueDepth.Set(float64(q.e.jrnl.QueueLen())) }()
+	q.e.m.CompressLag.Observe(time.Since(job.Due).Seconds())
+	if _, err := os.Stat(job.Path); os.IsNotExist(err) {
+		// already compressed or removed by retention
+		q.e.jrnl.Dequeue(job.Path)
+		return
+	}
+	var err error
+	switch {
+	case job.Checksum:
+		q.e.recordArchive(job.Path, job.Path, ManifestEntry{})
+	case job.Encrypt:
+		err = q.encrypt(job)
+	case job.Tier > 0:
+		err = q.recompress(job)
+	default:
+		err = q.compress(job)
+	}
+	if errors.Is(err, errUnknownCodec) {
+		// the policy changed since the job was queued; keep the archive as is
+		q.e.log.WithError(err).WithField("file", job.Path).Warn("dropping compression job")
+		q.e.jrnl.Dequeue(job.Path)
+		return
+	}
+	if err != nil {
+		q.e.m.CountError("compress")
+		q.e.log.WithError(err).WithField("file", job.Path).Warn("compression failed")
+		q.e.ntf.Send(notify.Event{Kind: notify.Error, Namespace: job.Namespace, File: job.Base, Archive: job.Path, Error: err.Error()})
+		job.Due = time.Now().Add(compressRetryDelay)
+		_ = q.e.jrnl.Enqueue(job)
+		return
+	}
+	q.e.jrnl.Dequeue(job.Path)
+	if q.e.shp != nil {
+		q.e.shp.nudge()
+	}
+}
+
+// compress performs the first compression of a rotated file.
+func (q *compressQueue) compress(job CompressJob) error {
+	c, err := codecFor(job.Codec)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(job.Path)
+	if err != nil {
+		return err
+	}
+	dst, err := compressFile(job.Path, c, job.Level)
+	if err != nil {
+		return err
+	}
+	q.e.recordArchive(dst, job.Path, ManifestEntry{Codec: c.Name(), Last: info.ModTime()})
+	var size int64
+	if fi, err := os.Stat(dst); err == nil {
+		size = fi.Size()
+	}
+	q.e.ntf.Send(notify.Event{Kind: notify.Compressed, Namespace: job.Namespace, File: job.Base, Archive: dst, Bytes: size})
+	a := ArchiveState{
+		Base:      job.Base,
+		Namespace: job.Namespace,
+		Codec:     c.Name(),
+		Level:     job.Level,
+		Rotated:   info.ModTime(),
+	}
+	q.e.jrnl.Archived(dst, job.Path, a)
+	if enc := q.e.encryptionFor(job.Namespace, job.Base); enc != nil {
+		// on failure the tier sweep queues the archive for encryption again
+		return q.e.encryptArchive(dst, a, enc)
+	}
+	return nil
+}
+
+// rescan queues uncompressed archives that were left behind, e.g. rotated
+// by a version that compressed from in-memory timers, and adopts compressed
+// archives the journal does not know so they take part in tiering.
+func (q *compressQueue) rescan() {
+	queued := map[string]bool{}
+	for _, job := range q.e.jrnl.Queued() {
+		queued[job.Path] = true
+	}
+	known := q.e.jrnl.Archives()
+	q.e.walkArchives(nil, "", known, func(a archiveFile) {
+		if queued[a.path] {
+			return
+		}
+		if _, ok := known[a.path]; ok {
+			return
+		}
+		if isEncrypted(a.path) {
+			var codec string
+			if c, ok := codecByExt(strings.TrimSuffix(a.path, crypt.Ext)); ok {
+				codec = c.Name()
+			}
+			q.e.jrnl.Archived(a.path, "", ArchiveState{Base: a.base, Namespace: a.namespace, Codec: codec, Rotated: a.info.ModTime(), KeyID: keyIDOf(a.path)})
+			return
+		}
+		if c, compressed := codecByExt(a.path); compressed {
+			q.e.jrnl.Archived(a.path, "", ArchiveState{Base: a.base, Namespace: a.namespace, Codec: c.Name(), Rotated: a.info.ModTime()})
+			return
+		}
+		pol := q.e.pol.EffectivePolicy(a.namespace, a.base)
+		if pol.CompressAfter <= 0 {
+			return
+		}
+		_ = q.add(CompressJob{Path: a.path, Base: a.base, Namespace: a.namespace, Codec: pol.Codec, Level: pol.CompressLevel, Due: a.info.ModTime().Add(pol.CompressAfter)})
+	})
+}
@@ -0,0 +1,50 @@
+package engine
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyChunk is the most copy_file_range is asked to move per call.
+const copyChunk = 1 << 30
+
+// cloneFile makes dst share src's extents with FICLONE (btrfs, xfs) and
+// leaves both offsets at the end of the clone. It reports false when the
+// filesystem cannot reflink.
+func cloneFile(dst, src *os.File) (int64, bool) {
+	if err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())); err != nil {
+		return 0, false
+	}
+	fi, err := dst.Stat()
+	if err != nil {
+		return 0, false
+	}
+	if _, err := src.Seek(fi.Size(), 0); err != nil {
+		return 0, false
+	}
+	if _, err := dst.Seek(fi.Size(), 0); err != nil {
+		return 0, false
+	}
+	return fi.Size(), true
+}
+
+// copyRange copies from src's offset to EOF in the kernel with
+// copy_file_range. It reports false, having copied nothing, when the kernel
+// or filesystem does not support it.
+func copyRange(dst, src *os.File) (int64, bool, error) {
+	var total int64
+	for {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dst.Fd()), nil, copyChunk, 0)
+		if err != nil {
+			if total == 0 && unsupported(err) {
+				return 0, false, nil
+			}
+			return total, true, err
+		}
+		if n == 0 {
+			return total, true, nil
+		}
+		total += int64(n)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package engine
+
+import "os"
+
+func cloneFile(dst, src *os.File) (int64, bool) {
+	return 0, false
+}
+
+func copyRange(dst, src *os.File) (int64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,79 @@
+package engine
+
+import (
+	"os"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// deletable reports whether retention or purging (action) may remove an
+// archive. With shipping enabled it must have been uploaded or be older
+// than the grace period, and no log shipper may still be reading it unless
+// it is older than the checkpoint maxDefer. Archives that are neither
+// compressed nor waiting to be are never shipped and not held back for it.
+func (e *Engine) deletable(ns, action, p string, mod time.Time) bool {
+	if e.shp != nil {
+		a, ok := e.jrnl.Archive(p)
+		shipped := ok && !a.Shipped.IsZero()
+		shippable := ok || e.jrnl.IsQueued(p)
+		if shippable && !shipped && (e.cfg.Shipping.Grace <= 0 || time.Since(mod) < e.cfg.Shipping.Grace) {
+			return false
+		}
+	}
+	if e.unread(p) > 0 {
+		maxDefer := e.cfg.Checkpoints.MaxDefer
+		if maxDefer <= 0 || time.Since(mod) < maxDefer {
+			e.m.DeletionsDeferred.WithLabelValues(ns, action).Inc()
+			return false
+		}
+	}
+	return true
+}
+
+// deferRotation reports whether a copytruncate or trim of f should wait
+// because a log shipper has not read all of it yet; the bytes it has not
+// read would be cut from under it. A file is deferred for at most maxDefer.
+func (e *Engine) deferRotation(f discover.FileInfo, tech string) bool {
+	if e.unread(f.Path) == 0 {
+		e.deferMu.Lock()
+		delete(e.deferred, f.Path)
+		e.deferMu.Unlock()
+		return false
+	}
+	e.deferMu.Lock()
+	since, ok := e.deferred[f.Path]
+	if !ok {
+		since = time.Now()
+		e.deferred[f.Path] = since
+	}
+	e.deferMu.Unlock()
+	if maxDefer := e.cfg.Checkpoints.MaxDefer; maxDefer > 0 && time.Since(since) >= maxDefer {
+		e.log.WithField("file", f.Path).Warn("log shipper still behind after maxDefer; rotating anyway")
+		e.deferMu.Lock()
+		delete(e.deferred, f.Path)
+		e.deferMu.Unlock()
+		return false
+	}
+	e.m.DeletionsDeferred.WithLabelValues(f.Group, tech).Inc()
+	return true
+}
+
+// unread returns how many bytes of the file at p a configured log shipper
+// has yet to read.
+func (e *Engine) unread(p string) int64 {
+	if e.ckpt == nil {
+		return 0
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0
+	}
+	set, err := e.ckpt.Positions()
+	if err != nil {
+		e.log.WithError(err).Warn("failed to read log shipper checkpoints")
+	}
+	dev, ino := util.FileID(fi)
+	return set.Unread(dev, ino, fi.Size())
+}
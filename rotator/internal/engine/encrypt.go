@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/crypt"
+)
+
+// encryptionFor returns the encryption settings of namespace, or nil if
+// its archives are kept in the clear. Namespace overrides do not apply to
+// the live files of host roots.
+func (e *Engine) encryptionFor(namespace, live string) *config.EncryptionConfig {
+	enc := &e.cfg.Defaults.Encryption
+	if o, ok := e.cfg.Overrides.Namespaces[namespace]; ok && o.Encryption != nil {
+		if r := e.rootFor(live); r == nil || !r.host {
+			enc = o.Encryption
+		}
+	}
+	if enc.KeyFile == "" {
+		return nil
+	}
+	return enc
+}
+
+// keyFor loads the current key of enc and its ID.
+func keyFor(enc *config.EncryptionConfig) ([]byte, string, error) {
+	key, err := crypt.LoadKey(enc.KeyFile)
+	if err != nil {
+		return nil, "", err
+	}
+	id := enc.KeyID
+	if id == "" {
+		id = filepath.Base(enc.KeyFile)
+	}
+	return key, id, nil
+}
+
+// validateEncryption makes sure every configured key can be loaded, so a
+// missing secret shows up at startup rather than at the first compression.
+// Archives are encrypted by the compression job, so encryption also needs
+// every policy to compress.
+func validateEncryption(cfg *config.Config) error {
+	encs := []*config.EncryptionConfig{&cfg.Defaults.Encryption}
+	for _, ns := range cfg.Overrides.Namespaces {
+		encs = append(encs, ns.Encryption)
+	}
+	encrypted := false
+	for _, enc := range encs {
+		if enc == nil || enc.KeyFile == "" {
+			continue
+		}
+		if _, _, err := keyFor(enc); err != nil {
+			return fmt.Errorf("encryption: %w", err)
+		}
+		encrypted = true
+	}
+	if !encrypted {
+		return nil
+	}
+	pols := []*config.PolicyConfig{&cfg.Defaults.Policy}
+	for _, r := range cfg.Roots {
+		pols = append(pols, r.Policy)
+	}
+	for _, ns := range cfg.Overrides.Namespaces {
+		pols = append(pols, ns.Policy)
+	}
+	for _, p := range cfg.Overrides.Paths {
+		pols = append(pols, p.Policy)
+	}
+	for i, p := range pols {
+		// overrides inherit a zero compressAfter from the defaults
+		if p != nil && (p.CompressAfter < 0 || i == 0 && p.CompressAfter == 0) {
+			return fmt.Errorf("encryption: compressAfter %s never compresses, so archives would stay unencrypted", p.CompressAfter)
+		}
+	}
+	return nil
+}
+
+// isEncrypted reports whether path names an encrypted archive.
+func isEncrypted(path string) bool {
+	return strings.HasSuffix(path, crypt.Ext)
+}
+
+// encryptArchive replaces the compressed archive at path with an encrypted
+// copy and records it.
+func (e *Engine) encryptArchive(path string, a ArchiveState, enc *config.EncryptionConfig) error {
+	key, id, err := keyFor(enc)
+	if err != nil {
+		return err
+	}
+	dst := path + crypt.Ext
+	if err := encryptFile(path, dst, id, key); err != nil {
+		return err
+	}
+	a.KeyID = id
+	e.jrnl.Archived(dst, path, a)
+	e.recordArchive(dst, path, ManifestEntry{Codec: a.Codec, KeyID: id, Last: a.Rotated})
+	return nil
+}
+
+// encryptFile encrypts src into dst through a temp file and removes src
+// once the result decrypts back to the same data.
+func encryptFile(src, dst, keyID string, key []byte) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	w, err := crypt.NewWriter(tmp, keyID, key)
+	if err != nil {
+		return err
+	}
+	want := sha256.New()
+	if _, err := io.Copy(w, io.TeeReader(in, want)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r, err := crypt.NewReader(tmp, func(string) ([]byte, error) { return key, nil })
+	if err != nil {
+		return err
+	}
+	got := sha256.New()
+	if _, err := io.Copy(got, r); err != nil {
+		return fmt.Errorf("verify %s: %w", dst, err)
+	}
+	if !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+		return fmt.Errorf("verify %s: checksum mismatch", dst)
+	}
+	if err := copyOwner(tmp, fi); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := copyTimes(tmp.Name(), fi); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// encrypt is a queued job that encrypts an archive compressed before its
+// namespace turned encryption on, or whose encryption failed.
+func (q *compressQueue) encrypt(job CompressJob) error {
+	enc := q.e.encryptionFor(job.Namespace, job.Base)
+	if enc == nil {
+		return nil
+	}
+	a, ok := q.e.jrnl.Archive(job.Path)
+	if !ok {
+		return fmt.Errorf("%s is not a known archive", job.Path)
+	}
+	return q.e.encryptArchive(job.Path, a, enc)
+}
+
+// keyIDOf reads the key ID from the header of an encrypted archive.
+func keyIDOf(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	id, _ := crypt.KeyID(f)
+	return id
+}
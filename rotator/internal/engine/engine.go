@@ -2,42 +2,211 @@ package engine
 
 import (
 	"context"
-	"io/fs"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/checkpoint"
 	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
 	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
 	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
-	"github.com/tapasyadubey/log-rotate-util/rotator/pkg/budget"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/notify"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/policy"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/ship"
 )
 
 type Engine struct {
-	cfg  *config.Config
-	m    *metrics.Registry
-	log  *log.Entry
-	jrnl *Journal
-	bud  *budget.Tracker
+	cfg   *config.Config
+	m     *metrics.Registry
+	log   *log.Entry
+	jrnl  *Journal
+	pol   *policy.Engine
+	roots []*rootState
+	cq    *compressQueue
+	ntf   *notify.Notifier
+	shp   *shipper           // nil unless shipping is configured
+	ckpt  *checkpoint.Reader // nil unless shipper checkpoints are configured
+	bg    sync.WaitGroup     // reopen checks and shipping
+
+	hookMu      sync.Mutex
+	lastActions map[string]*lastAction
+
+	deferMu  sync.Mutex
+	deferred map[string]time.Time // live files waiting for log shippers, since
 }
 
 func New(cfg *config.Config, m *metrics.Registry, logger *log.Entry) (*Engine, error) {
-	j := newJournal("/var/lib/rotator/state.json")
-	b := budget.New(int64(cfg.Defaults.Budgets.PerNamespaceBytes))
-	return &Engine{cfg: cfg, m: m, log: logger, jrnl: j, bud: b}, nil
+	if err := validatePolicies(cfg); err != nil {
+		return nil, err
+	}
+	if err := checkpoint.Validate(cfg.Checkpoints.Sources); err != nil {
+		return nil, err
+	}
+	if err := validateEncryption(cfg); err != nil {
+		return nil, err
+	}
+	roots, err := newRoots(cfg)
+	if err != nil {
+		return nil, err
+	}
+	j, err := newJournal(cfg.State.Path, cfg.State.SnapshotEvery)
+	if j == nil {
+		return nil, err
+	}
+	if err != nil {
+		// start with what could be recovered, but make the damage visible
+		if errors.Is(err, errJournalCorrupt) {
+			m.JournalCorruptions.Inc()
+		} else {
+			m.CountError("journal")
+		}
+		logger.WithError(err).Error("journal could not be fully loaded; damaged files were set aside")
+	}
+	e := &Engine{cfg: cfg, m: m, log: logger, jrnl: j, pol: policy.New(cfg, m), roots: roots, deferred: map[string]time.Time{}}
+	if len(cfg.Checkpoints.Sources) > 0 {
+		e.ckpt = checkpoint.New(cfg.Checkpoints.Sources)
+	}
+	e.cq = newCompressQueue(e, cfg.Compression.Workers, cfg.Compression.QueueSize)
+	e.ntf = notify.New(cfg.Notify, m, logger)
+	if cfg.Shipping.Endpoint != "" {
+		client, err := ship.New(cfg.Shipping)
+		if err != nil {
+			_ = j.Close()
+			return nil, err
+		}
+		e.shp = &shipper{e: e, client: client, wake: make(chan struct{}, 1)}
+	}
+	e.recoverIntents()
+	m.CompressQueueDepth.Set(float64(j.QueueLen()))
+	return e, nil
+}
+
+// validatePolicies rejects policies naming a codec that is not registered
+// or a level that codec does not accept, tiers that are not in ascending age order, malformed archive names and
+// trim without bytes to keep, which would empty the live file.
+func validatePolicies(cfg *config.Config) error {
+	pols := []*config.PolicyConfig{&cfg.Defaults.Policy}
+	for _, r := range cfg.Roots {
+		pols = append(pols, r.Policy)
+	}
+	for _, ns := range cfg.Overrides.Namespaces {
+		pols = append(pols, ns.Policy)
+	}
+	for _, p := range cfg.Overrides.Paths {
+		pols = append(pols, p.Policy)
+	}
+	for _, p := range pols {
+		if p == nil {
+			continue
+		}
+		if _, err := namerFor(p.ArchiveName); err != nil {
+			return err
+		}
+		if p.Codec != "" || p.CompressLevel != 0 {
+			// a level set on its own applies to the default policy's codec
+			name := p.Codec
+			if name == "" {
+				name = cfg.Defaults.Policy.Codec
+			}
+			if err := checkCodecLevel(name, p.CompressLevel); err != nil {
+				return err
+			}
+		}
+		if p.Reopen != nil && p.Reopen.Signal != "" {
+			if _, err := lookupSignal(p.Reopen.Signal); err != nil {
+				return err
+			}
+		}
+		if p.TrimKeep < 0 || p.DefaultMode == "trim" && p.TrimKeep == 0 && cfg.Defaults.Policy.TrimKeep <= 0 {
+			return fmt.Errorf("trim needs a positive trimKeep")
+		}
+		if p.TrimMethod != "" && p.TrimMethod != trimCollapse && p.TrimMethod != trimPunch {
+			return fmt.Errorf("unknown trim method %q (want %s or %s)", p.TrimMethod, trimCollapse, trimPunch)
+		}
+		for i, t := range p.Tiers {
+			if err := checkCodecLevel(t.Codec, t.Level); err != nil {
+				return err
+			}
+			if i > 0 && t.After <= p.Tiers[i-1].After {
+				return fmt.Errorf("archive tiers must be ordered by ascending age (%s after %s)", t.After, p.Tiers[i-1].After)
+			}
+		}
+	}
+	return nil
+}
+
+// checkCodecLevel resolves the codec named by a policy and checks that it
+// accepts level.
+func checkCodecLevel(name string, level int) error {
+	c, err := codecFor(name)
+	if err != nil {
+		return err
+	}
+	if err := c.CheckLevel(level); err != nil {
+		return fmt.Errorf("codec %s: %w", c.Name(), err)
+	}
+	return nil
+}
+
+// Start runs background work (the compression queue, archive shipping and
+// webhook delivery) until ctx is done.
+func (e *Engine) Start(ctx context.Context) {
+	e.cq.start(ctx)
+	e.ntf.Start(ctx)
+	if e.shp != nil {
+		e.bg.Add(1)
+		go func() {
+			defer e.bg.Done()
+			e.shp.run(ctx)
+		}()
+	}
+}
+
+// Close waits for background work to stop and flushes the journal to a
+// final snapshot.
+func (e *Engine) Close() error {
+	e.cq.wait()
+	e.bg.Wait()
+	e.ntf.Wait()
+	return e.jrnl.Close()
 }
 
 func (e *Engine) ProcessFile(ctx context.Context, f discover.FileInfo, pol config.PolicyConfig) error {
-	_ = ctx
+	if f.Group == "" {
+		f.Group = f.Namespace
+	}
+	err := e.processFile(ctx, f, pol)
+	if err != nil {
+		e.ntf.Send(notify.Event{Kind: notify.Error, Namespace: f.Namespace, Pod: f.Pod, File: f.Path, Error: err.Error()})
+	}
+	return err
+}
+
+func (e *Engine) processFile(ctx context.Context, f discover.FileInfo, pol config.PolicyConfig) error {
+	st, change := e.jrnl.Observe(f.Path, f.Dev, f.Inode, f.Size)
+	if change == changeReplaced || change == changeTruncated {
+		e.m.FileResets.WithLabelValues(f.Group, change).Inc()
+		e.log.WithFields(map[string]interface{}{
+			"file":      f.Path,
+			"namespace": f.Namespace,
+			"change":    change,
+		}).Info("file started over outside the rotator")
+	}
+	size := f.Size
+	if pol.DefaultMode == "trim" {
+		size -= headHole(f.Path)
+	}
 	shouldRotate := false
-	if pol.Size > 0 && f.Size >= int64(pol.Size) {
+	if pol.Size > 0 && size >= int64(pol.Size) {
 		shouldRotate = true
 	}
 	if !shouldRotate && pol.Age > 0 {
-		age := time.Since(time.UnixMilli(f.ModTimeMs))
+		// age of the current contents, not of the last write
+		age := time.Since(st.FirstSeen)
 		if age >= pol.Age {
 			shouldRotate = true
 		}
@@ -51,85 +220,170 @@ func (e *Engine) ProcessFile(ctx context.Context, f discover.FileInfo, pol confi
 	if !shouldRotate {
 		return nil
 	}
-
-	var target string
-	var bytes int64
-	var err error
-	tech := pol.DefaultMode
-	switch tech {
-	case "copytruncate":
-		target, bytes, err = rotateByCopyTruncate(f.Path)
-	default:
-		tech = "rename"
-		target, bytes, err = rotateByRename(f.Path)
-	}
+	namer, err := namerFor(pol.ArchiveName)
 	if err != nil {
 		return err
 	}
-	e.jrnl.Record(f.Path, "rotated")
-	e.m.RotationsTotal.WithLabelValues(f.Namespace, tech).Inc()
-	e.m.BytesRotatedTotal.WithLabelValues(f.Namespace).Add(float64(bytes))
-	e.bud.Add(f.Namespace, bytes)
-	e.m.NamespaceUsageBytes.WithLabelValues(f.Namespace).Set(float64(e.bud.Get(f.Namespace)))
-
-	if e.bud.OverLimit(f.Namespace) {
-		go purgeOldestForNamespace(f.Namespace, e.cfg.Defaults.Discovery.Path, int64(e.cfg.Defaults.Budgets.PerNamespaceBytes), e.m)
+	if namer.archiveOfSibling(f.Path) {
+		// an archive whose name the includes match, never a live file
+		return nil
 	}
 
-	if pol.CompressAfter > 0 {
-		go func(path string, delay time.Duration) {
-			time.Sleep(delay)
-			_, _ = compressGzip(path)
-		}(target, pol.CompressAfter)
+	fi, err := os.Stat(f.Path)
+	if err != nil {
+		return err
 	}
-
-	_ = enforceRetention(f.Path, pol.KeepFiles, pol.KeepDays)
-	return nil
-}
-
-// purgeOldestForNamespace walks the discovery path and removes oldest rotated archives for the namespace until under limit.
-func purgeOldestForNamespace(namespace, root string, limit int64, m *metrics.Registry) {
-	type item struct {
-		path string
-		size int64
-		mod  int64
-	}
-	var items []item
-	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
-		if err != nil || d.IsDir() {
+	tech := pol.DefaultMode
+	if tech == "auto" {
+		var ok bool
+		if tech, ok = e.autoMode(f, fi); !ok {
 			return nil
 		}
-		// expect path root/<ns>/<pod>/file.log.N(.gz)
-		rel, rerr := filepath.Rel(root, path)
-		if rerr != nil {
-			return nil
+	}
+	if tech != "copytruncate" && tech != "trim" {
+		tech = "rename"
+	}
+	// trim keeps the file in place and archives the dropped lines only on request
+	archive := tech != "trim" || (pol.TrimArchive != nil && *pol.TrimArchive)
+	if (tech == "copytruncate" || tech == "trim") && e.deferRotation(f, tech) {
+		return nil
+	}
+	var dir, target string
+	vars := nameVars{Namespace: f.Namespace, Pod: f.Pod, Container: f.Container, Time: time.Now()}
+	if archive {
+		if dir, err = e.archiveDirFor(f.Path, pol); err != nil {
+			return err
 		}
-		parts := strings.Split(filepath.ToSlash(rel), "/")
-		if len(parts) < 3 || parts[0] != namespace {
-			return nil
+	}
+	hooks := pol.Hooks
+	if hooks != nil && len(hooks.PreRotate) > 0 {
+		// the hook sees the name the archive will get; nothing on disk has
+		// changed yet, so a veto leaves the file and its archives as they are
+		planned := ""
+		if archive {
+			planned, _ = namer.next(dir, f.Path, vars)
 		}
-		base := parts[len(parts)-1]
-		if !strings.Contains(base, ".") {
+		if err := e.runHook(ctx, hookPreRotate, hooks, hooks.PreRotate, f.Group, hookEnv(f, planned)); err != nil {
+			// a failing prerotate vetoes the rotation
 			return nil
 		}
-		// treat as rotated archive
-		info, ierr := d.Info()
-		if ierr != nil {
-			return nil
+	}
+	if archive {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if namer.shift {
+			if err := e.shiftArchives(dir, f.Path, namer); err != nil {
+				e.jrnl.Failed(f.Path, err)
+				return err
+			}
 		}
-		items = append(items, item{path: path, size: info.Size(), mod: info.ModTime().Unix()})
+		if target, err = namer.next(dir, f.Path, vars); err != nil {
+			e.jrnl.Failed(f.Path, err)
+			return err
+		}
+	}
+	var staging string
+	if tech == "rename" && !sameDevice(filepath.Dir(f.Path), dir) {
+		staging = stagingName(f.Path)
+	}
+	// write-ahead: the intent must be durable before the filesystem changes
+	in, err := e.jrnl.Begin(tech, f.Path, target, staging, fi.Mode(), fi.Size())
+	if err != nil {
+		e.m.CountError("journal")
+		return err
+	}
+	onCopied := func() error { return e.jrnl.Advance(in, phaseCopied) }
+	var bytes int64
+	switch tech {
+	case "copytruncate":
+		var res truncateResult
+		lines := pol.LineBoundary != nil && *pol.LineBoundary
+		res, err = rotateByCopyTruncate(f.Path, target, lines, onCopied)
+		bytes = res.copied
+		if res.strategy != "" {
+			e.m.TruncateCopySeconds.WithLabelValues(res.strategy).Observe(res.took.Seconds())
+		}
+		e.m.TruncateTailBytes.WithLabelValues(f.Group).Add(float64(res.tail))
+		if res.lost > 0 {
+			e.m.TruncateLostBytes.WithLabelValues(f.Group).Add(float64(res.lost))
+			e.log.WithField("file", f.Path).WithField("bytes", res.lost).Warn("copytruncate lost bytes written during truncate")
+		}
+	case "trim":
+		var method string
+		bytes, method, err = rotateByTrim(f.Path, target, int64(pol.TrimKeep), pol.TrimMethod, onCopied)
+		if method == trimPunch && pol.TrimMethod != trimPunch {
+			e.log.WithField("file", f.Path).Info("filesystem cannot collapse ranges; punched holes instead")
+		}
+		if err != nil && bytes > 0 {
+			// the lines were archived and dropped; only zeroing what is left
+			// of the last one in the first block failed
+			e.log.WithError(err).WithField("file", f.Path).Warn("failed to zero the rest of the trimmed block")
+			err = nil
+		}
+	default:
+		bytes, err = rotateByRename(f.Path, target, staging)
+	}
+	if err != nil {
+		e.resolveIntent(in)
+		e.jrnl.Failed(f.Path, err)
+		return err
+	}
+	if tech == "trim" && bytes == 0 {
+		// no line ends before the kept region; nothing was dropped
+		e.jrnl.Finish(in, false)
 		return nil
+	}
+	in.Size = bytes
+	e.jrnl.Finish(in, true)
+	if archive {
+		e.noteArchive(target, ManifestEntry{First: st.FirstSeen, Last: fi.ModTime()})
+	}
+	e.ntf.Send(notify.Event{Kind: notify.Rotated, Namespace: f.Namespace, Pod: f.Pod, File: f.Path, Archive: target, Bytes: bytes})
+	if tech == "rename" && pol.Reopen != nil {
+		e.signalWriters(ctx, f, pol.Reopen, fi, target, bytes)
+	}
+	if hooks != nil && len(hooks.PostRotate) > 0 {
+		_ = e.runHook(ctx, hookPostRotate, hooks, hooks.PostRotate, f.Group, hookEnv(f, target))
+	}
+	if hooks != nil && len(hooks.LastAction) > 0 {
+		e.queueLastAction(f, hooks)
+	}
+	r := e.rootFor(f.Path)
+	rootName := ""
+	if r != nil {
+		rootName = r.name
+	}
+	e.m.RotationsTotal.WithLabelValues(rootName, f.Group, tech).Inc()
+	e.m.BytesRotatedTotal.WithLabelValues(rootName, f.Group).Add(float64(bytes))
+	if !archive {
+		return nil
+	}
+	if r != nil {
+		r.bud.Add(f.Group, bytes)
+		e.m.NamespaceUsageBytes.WithLabelValues(r.name, f.Group).Set(float64(r.bud.Get(f.Group)))
+		if r.bud.OverLimit(f.Group) {
+			go e.purgeOldestForNamespace(r, f.Group)
+		}
+	}
+
+	// the workers checksum the archive: after compressing it, or right away
+	// if it is kept as is
+	job := CompressJob{Path: target, Base: f.Path, Namespace: f.Group, Checksum: true, Due: time.Now()}
+	if pol.CompressAfter > 0 {
+		job = CompressJob{Path: target, Base: f.Path, Namespace: f.Group, Codec: pol.Codec, Level: pol.CompressLevel, Due: time.Now().Add(pol.CompressAfter)}
+	}
+	if err := e.cq.add(job); err != nil {
+		e.m.CountError("journal")
+		e.log.WithError(err).WithField("file", target).Warn("failed to queue compression")
+	}
+
+	removed, _ := enforceRetention(dir, f.Path, namer, pol.KeepFiles, pol.KeepDays, func(p string, mod time.Time) bool {
+		return e.deletable(f.Group, "retention", p, mod)
 	})
-	// sort oldest first
-	sort.Slice(items, func(i, j int) bool { return items[i].mod < items[j].mod })
-	var total int64
-	for _, it := range items {
-		total += it.size
-	}
-	for total > limit && len(items) > 0 {
-		it := items[0]
-		_ = os.Remove(it.path)
-		total -= it.size
-		items = items[1:]
+	e.forgetArchives(removed...)
+	for _, p := range removed {
+		e.ntf.Send(notify.Event{Kind: notify.RetentionDeleted, Namespace: f.Namespace, Pod: f.Pod, File: f.Path, Archive: p})
 	}
+	return nil
 }
@@ -3,9 +3,39 @@ package engine
 import (
 	"compress/gzip"
 	"io"
+	"time"
 )
 
-func newGzipWriter(w io.Writer) (*gzip.Writer, error) {
-	zw := gzip.NewWriter(w)
-	return zw, nil
+func init() { registerCodec(gzipCodec{}) }
+
+// gzipCodec uses the stdlib writer; levels are 1 (fastest) to 9 (best).
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+func (gzipCodec) Ext() string  { return ".gz" }
+
+func (gzipCodec) CheckLevel(level int) error { return levelIn(level, 1, 9) }
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// NewFileWriter also records the original file's name and modification
+// time in the gzip header, so gunzip -N restores them.
+func (c gzipCodec) NewFileWriter(w io.Writer, level int, name string, mod time.Time) (io.WriteCloser, error) {
+	zw, err := c.NewWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	gw := zw.(*gzip.Writer)
+	gw.Name = name
+	gw.ModTime = mod
+	return gw, nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
 }
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+)
+
+// Hook names, also used as metric label values.
+const (
+	hookPreRotate  = "prerotate"
+	hookPostRotate = "postrotate"
+	hookLastAction = "lastaction"
+)
+
+const (
+	defaultHookTimeout = 30 * time.Second
+	// hookOutputLimit caps the output kept for the log.
+	hookOutputLimit = 4 << 10
+)
+
+// lastAction is a lastaction hook waiting for the end of the scan cycle,
+// with the files it rotated.
+type lastAction struct {
+	hooks *config.HooksConfig
+	ns    string
+	group string
+	files []string
+}
+
+// runHook runs one hook command with the file's details in its environment:
+// ROTATOR_FILE, ROTATOR_NAMESPACE, ROTATOR_POD and ROTATOR_ARCHIVE. Commands
+// are argv lists run without a shell; the image has none.
+func (e *Engine) runHook(ctx context.Context, name string, h *config.HooksConfig, argv []string, ns string, env []string) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), env...)
+	// don't wait forever for children that inherited the output pipe
+	cmd.WaitDelay = time.Second
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	result := "ok"
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		result = "timeout"
+	case err != nil:
+		result = "failed"
+	}
+	e.m.HookRuns.WithLabelValues(ns, name, result).Inc()
+	output := out.String()
+	if len(output) > hookOutputLimit {
+		output = output[:hookOutputLimit] + "..."
+	}
+	entry := e.log.WithFields(map[string]interface{}{
+		"hook":      name,
+		"command":   strings.Join(argv, " "),
+		"namespace": ns,
+		"result":    result,
+		"output":    strings.TrimSpace(output),
+	})
+	if err != nil {
+		entry.WithError(err).Warn("hook failed")
+		return err
+	}
+	entry.Debug("hook ran")
+	return nil
+}
+
+func hookEnv(f discover.FileInfo, archive string) []string {
+	return []string{
+		"ROTATOR_FILE=" + f.Path,
+		"ROTATOR_NAMESPACE=" + f.Namespace,
+		"ROTATOR_POD=" + f.Pod,
+		"ROTATOR_CONTAINER=" + f.Container,
+		"ROTATOR_GROUP=" + f.Group,
+		"ROTATOR_ARCHIVE=" + archive,
+	}
+}
+
+// queueLastAction remembers a rotation for the policy's lastaction hook.
+// Rotations share a hook run when their policies have the same command.
+func (e *Engine) queueLastAction(f discover.FileInfo, h *config.HooksConfig) {
+	key := f.Group + "\x00" + strings.Join(h.LastAction, "\x00")
+	e.hookMu.Lock()
+	defer e.hookMu.Unlock()
+	if e.lastActions == nil {
+		e.lastActions = map[string]*lastAction{}
+	}
+	la, ok := e.lastActions[key]
+	if !ok {
+		la = &lastAction{hooks: h, ns: f.Namespace, group: f.Group}
+		e.lastActions[key] = la
+	}
+	la.files = append(la.files, f.Path)
+}
+
+// EndCycle runs the lastaction hooks of the policies that rotated files
+// since the previous call. The files are in ROTATOR_FILES, one per line.
+func (e *Engine) EndCycle(ctx context.Context) {
+	e.hookMu.Lock()
+	pending := e.lastActions
+	e.lastActions = nil
+	e.hookMu.Unlock()
+	for _, la := range pending {
+		env := []string{
+			"ROTATOR_NAMESPACE=" + la.ns,
+			"ROTATOR_GROUP=" + la.group,
+			"ROTATOR_FILES=" + strings.Join(la.files, "\n"),
+		}
+		_ = e.runHook(ctx, hookLastAction, la.hooks, la.hooks.LastAction, la.group, env)
+	}
+}
@@ -1,51 +1,697 @@
 package engine
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// Intent phases. A rotation is recorded as begun before the filesystem is
+// touched and advanced once its archive is durable.
+const (
+	phaseBegun  = "begun"
+	phaseCopied = "copied"
+)
+
+// defaultSnapshotEvery is how many log records are appended before the
+// journal is folded into a fresh snapshot.
+const defaultSnapshotEvery = 1000
+
+// errJournalCorrupt is returned by newJournal when the snapshot or log could
+// not be fully read. The journal is still usable with whatever was recovered.
+var errJournalCorrupt = errors.New("journal corrupt")
+
+// Changes reported by Observe when a file no longer matches what the journal
+// last saw.
+const (
+	changeNew       = "new"
+	changeReplaced  = "replaced"
+	changeTruncated = "truncated"
+)
+
+// Intent is a write-ahead record of a rotation in progress.
+type Intent struct {
+	ID      string      `json:"id"`
+	Op      string      `json:"op"` // rename | copytruncate | trim
+	Path    string      `json:"path"`
+	Target  string      `json:"target"`
+	Staging string      `json:"staging,omitempty"` // rename across filesystems
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	Phase   string      `json:"phase"`
+	Started time.Time   `json:"started"`
+}
+
+// FileState is what the journal knows about a live log file. FirstSeen is
+// reset whenever the file starts over (rotation, replacement, truncation),
+// so it is the age of the current contents.
+type FileState struct {
+	Dev         uint64    `json:"dev,omitempty"`
+	Ino         uint64    `json:"ino,omitempty"`
+	FirstSeen   time.Time `json:"firstSeen"`
+	LastRotated time.Time `json:"lastRotated"`
+	LastSize    int64     `json:"lastSize,omitempty"`
+	Rotations   int       `json:"rotations,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+
+	seenSize int64 // last observed size, kept in memory only
+}
+
+// UnmarshalJSON accepts the version 1 journal format, where each file mapped
+// to a bare action string.
+func (s *FileState) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		*s = FileState{}
+		return nil
+	}
+	type plain FileState
+	return json.Unmarshal(b, (*plain)(s))
+}
+
+// CompressJob is a rotated archive waiting to be compressed.
+// Tier 0 is the first compression of a rotated file; tier N > 0 re-compresses
+// an existing archive with the policy's Nth archive tier.
+type CompressJob struct {
+	Path      string    `json:"path"`
+	Base      string    `json:"base,omitempty"`
+	Namespace string    `json:"namespace"`
+	Codec     string    `json:"codec,omitempty"`
+	Level     int       `json:"level,omitempty"`
+	Tier      int       `json:"tier,omitempty"`
+	Encrypt   bool      `json:"encrypt,omitempty"`  // only encrypt an existing archive
+	Checksum  bool      `json:"checksum,omitempty"` // only record the checksum of an archive kept as is
+	Due       time.Time `json:"due"`
+}
+
+// ArchiveState describes a compressed archive and where it is in the tiered
+// lifecycle. Rotated is the time of the newest data it holds. KeyID is set
+// once the archive is encrypted; encrypted archives stay in their tier.
+type ArchiveState struct {
+	Base      string    `json:"base"`
+	Namespace string    `json:"namespace"` // the group in host mode
+	Codec     string    `json:"codec"`
+	Level     int       `json:"level,omitempty"`
+	Tier      int       `json:"tier,omitempty"`
+	Rotated   time.Time `json:"rotated"`
+	Shipped   time.Time `json:"shipped"`       // zero until uploaded
+	Key       string    `json:"key,omitempty"` // object key of the upload
+	KeyID     string    `json:"keyId,omitempty"`
+}
+
 type journalState struct {
-	Version int               `json:"version"`
-	Files   map[string]string `json:"files"`
+	Version  int                      `json:"version"`
+	Files    map[string]*FileState    `json:"files"`
+	Intents  map[string]*Intent       `json:"intents,omitempty"`
+	Compress map[string]*CompressJob  `json:"compress,omitempty"`
+	Archives map[string]*ArchiveState `json:"archives,omitempty"`
+	NextID   uint64                   `json:"nextId,omitempty"`
 }
 
+// logRecord is one line of the append-only log. Records are idempotent so the
+// log can be replayed over a snapshot that already contains some of them.
+type logRecord struct {
+	Kind    string        `json:"k"` // file | intent | finish | enqueue | dequeue | archive | move
+	Path    string        `json:"p,omitempty"`
+	From    string        `json:"f,omitempty"` // archive: previous path, now gone
+	State   *FileState    `json:"s,omitempty"`
+	Intent  *Intent       `json:"i,omitempty"`
+	Job     *CompressJob  `json:"j,omitempty"`
+	Archive *ArchiveState `json:"ar,omitempty"`
+	ID      string        `json:"id,omitempty"`
+}
+
+// Journal persists rotation state as a snapshot (path) plus an append-only,
+// fsynced log (path + ".log"). Each log line carries a CRC32 so corruption is
+// detected rather than silently replayed.
 type Journal struct {
-	mu   sync.Mutex
-	path string
-	st   journalState
+	mu            sync.Mutex
+	path          string
+	logPath       string
+	log           *os.File
+	appended      int
+	unsaved       int // changes kept in memory until the next snapshot
+	snapshotEvery int
+	st            journalState
 }
 
-func newJournal(path string) *Journal {
-	j := &Journal{path: path, st: journalState{Version: 1, Files: map[string]string{}}}
-	_ = j.load()
-	return j
+func newJournal(path string, snapshotEvery int) (*Journal, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = defaultSnapshotEvery
+	}
+	j := &Journal{
+		path:          path,
+		logPath:       path + ".log",
+		snapshotEvery: snapshotEvery,
+		st:            emptyState(),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	loadErr := j.load()
+	if loadErr != nil {
+		// keep the damaged files for inspection before they are rewritten
+		stamp := time.Now().UTC().Format("20060102T150405")
+		_ = os.Rename(j.path, j.path+".corrupt-"+stamp)
+		_ = os.Rename(j.logPath, j.logPath+".corrupt-"+stamp)
+	}
+	// fold whatever was recovered into a clean snapshot and start a new log
+	if err := j.snapshot(); err != nil {
+		return nil, err
+	}
+	return j, loadErr
+}
+
+func emptyState() journalState {
+	return journalState{Version: 3, Files: map[string]*FileState{}, Intents: map[string]*Intent{}, Compress: map[string]*CompressJob{}, Archives: map[string]*ArchiveState{}}
 }
 
 func (j *Journal) load() error {
+	var errs []error
 	b, err := os.ReadFile(j.path)
-	if err != nil {
-		return nil
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		errs = append(errs, err)
+	default:
+		var s journalState
+		if err := json.Unmarshal(b, &s); err != nil {
+			errs = append(errs, fmt.Errorf("%w: snapshot %s: %v", errJournalCorrupt, j.path, err))
+		} else {
+			if s.Files == nil {
+				s.Files = map[string]*FileState{}
+			}
+			s.Version = 3
+			if s.Intents == nil {
+				s.Intents = map[string]*Intent{}
+			}
+			if s.Compress == nil {
+				s.Compress = map[string]*CompressJob{}
+			}
+			if s.Archives == nil {
+				s.Archives = map[string]*ArchiveState{}
+			}
+			j.st = s
+		}
+	}
+	if err := j.replay(); err != nil {
+		errs = append(errs, err)
 	}
-	var s journalState
-	if err := json.Unmarshal(b, &s); err != nil {
+	return errors.Join(errs...)
+}
+
+// replay applies the log on top of the loaded snapshot. A torn final line is
+// the expected result of a crash mid-append and is dropped; anything else
+// that fails its checksum is reported.
+func (j *Journal) replay() error {
+	b, err := os.ReadFile(j.logPath)
+	if os.IsNotExist(err) {
 		return nil
 	}
-	j.st = s
+	if err != nil {
+		return err
+	}
+	lines := bytes.Split(b, []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		last := i == len(lines)-1 // no trailing newline: torn write
+		rec, err := decodeRecord(line)
+		if err != nil {
+			if last {
+				return nil
+			}
+			return fmt.Errorf("%w: log %s line %d: %v", errJournalCorrupt, j.logPath, i+1, err)
+		}
+		j.apply(rec)
+	}
+	return nil
+}
+
+func encodeRecord(rec logRecord) ([]byte, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(body), body)
+	return []byte(line), nil
+}
+
+func decodeRecord(line []byte) (logRecord, error) {
+	var rec logRecord
+	sum, body, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return rec, errors.New("missing checksum")
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil {
+		return rec, err
+	}
+	if crc32.ChecksumIEEE(body) != uint32(want) {
+		return rec, errors.New("checksum mismatch")
+	}
+	err = json.Unmarshal(body, &rec)
+	return rec, err
+}
+
+func (j *Journal) apply(rec logRecord) {
+	switch rec.Kind {
+	case "file":
+		j.setFile(rec.Path, rec.State)
+	case "intent":
+		if rec.Intent == nil {
+			return
+		}
+		in := *rec.Intent
+		j.st.Intents[in.ID] = &in
+		if id, err := strconv.ParseUint(in.ID, 10, 64); err == nil && id > j.st.NextID {
+			j.st.NextID = id
+		}
+	case "finish":
+		delete(j.st.Intents, rec.ID)
+		j.setFile(rec.Path, rec.State)
+	case "enqueue":
+		if rec.Job != nil {
+			job := *rec.Job
+			j.st.Compress[job.Path] = &job
+		}
+	case "dequeue":
+		delete(j.st.Compress, rec.Path)
+	case "archive":
+		if rec.From != "" {
+			delete(j.st.Archives, rec.From)
+		}
+		if rec.Archive != nil {
+			a := *rec.Archive
+			j.st.Archives[rec.Path] = &a
+		}
+	case "move":
+		if job, ok := j.st.Compress[rec.From]; ok {
+			delete(j.st.Compress, rec.From)
+			job.Path = rec.Path
+			j.st.Compress[rec.Path] = job
+		}
+		if a, ok := j.st.Archives[rec.From]; ok {
+			delete(j.st.Archives, rec.From)
+			j.st.Archives[rec.Path] = a
+		}
+	}
+}
+
+// setFile stores a copy of st, keeping the in-memory observed size.
+func (j *Journal) setFile(path string, st *FileState) {
+	if st == nil {
+		return
+	}
+	next := *st
+	if cur, ok := j.st.Files[path]; ok && next.seenSize == 0 {
+		next.seenSize = cur.seenSize
+	}
+	j.st.Files[path] = &next
+}
+
+// append writes rec to the log and fsyncs it. State must only be mutated
+// after append succeeds. Callers hold j.mu.
+func (j *Journal) append(rec logRecord) error {
+	line, err := encodeRecord(rec)
+	if err != nil {
+		return err
+	}
+	if j.log == nil {
+		f, err := os.OpenFile(j.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		j.log = f
+	}
+	if _, err := j.log.Write(line); err != nil {
+		return err
+	}
+	if err := j.log.Sync(); err != nil {
+		return err
+	}
+	j.apply(rec)
+	j.appended++
+	if j.appended+j.unsaved >= j.snapshotEvery {
+		// the record is already durable in the log; a failed snapshot is retried later
+		_ = j.snapshot()
+	}
 	return nil
 }
 
-func (j *Journal) save() error {
-	_ = os.MkdirAll(filepath.Dir(j.path), 0o755)
-	b, _ := json.MarshalIndent(j.st, "", "  ")
-	return os.WriteFile(j.path, b, 0o644)
+// snapshot compacts state, writes it through a temp file and rename, then
+// starts an empty log. Callers hold j.mu (or own j exclusively).
+func (j *Journal) snapshot() error {
+	j.compact()
+	b, err := json.MarshalIndent(j.st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(j.path, b, 0o644); err != nil {
+		return err
+	}
+	if j.log != nil {
+		_ = j.log.Close()
+		j.log = nil
+	}
+	// a crash before this truncate only means replaying records already in the snapshot
+	f, err := os.OpenFile(j.logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	j.log = f
+	j.appended, j.unsaved = 0, 0
+	return nil
+}
+
+// compact drops entries for files that no longer exist. Paths with a pending
+// intent are kept since recovery may still need to recreate them; compression
+// jobs whose source is gone have nothing left to do.
+func (j *Journal) compact() {
+	pending := map[string]bool{}
+	for _, in := range j.st.Intents {
+		pending[in.Path] = true
+	}
+	for p := range j.st.Files {
+		if pending[p] {
+			continue
+		}
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			delete(j.st.Files, p)
+		}
+	}
+	for p := range j.st.Compress {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			delete(j.st.Compress, p)
+		}
+	}
+	for p := range j.st.Archives {
+		if _, err := os.Stat(p); os.IsNotExist(err) {
+			delete(j.st.Archives, p)
+		}
+	}
+}
+
+// writeFileAtomic writes data to a temp file in the same directory, fsyncs
+// it, renames it over path and fsyncs the directory.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	if _, err := w.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
 }
 
-func (j *Journal) Record(path, action string) {
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// Observe compares a discovered file with its journal entry and returns the
+// current state plus what changed, if anything. Only identity changes are
+// persisted, and a file seen for the first time or started over is not
+// logged on its own: the first scan of a busy node would otherwise cost an
+// fsync per file. It reaches disk with the next snapshot, which such
+// changes also count towards. The observed size is tracked in memory to
+// spot truncation.
+func (j *Journal) Observe(path string, dev, ino uint64, size int64) (FileState, string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	cur, ok := j.st.Files[path]
+	var change string
+	switch {
+	case !ok || cur.FirstSeen.IsZero():
+		change = changeNew
+	case cur.Ino != 0 && (cur.Dev != dev || cur.Ino != ino):
+		change = changeReplaced
+	case size < cur.seenSize:
+		change = changeTruncated
+	case cur.Ino == 0 && ino != 0:
+		// adopt the identity of a file recreated by rotation
+		next := *cur
+		next.Dev, next.Ino = dev, ino
+		_ = j.append(logRecord{Kind: "file", Path: path, State: &next})
+	}
+	if change != "" {
+		next := FileState{Dev: dev, Ino: ino, FirstSeen: now}
+		if ok {
+			next.LastRotated = cur.LastRotated
+			next.LastSize = cur.LastSize
+			next.Rotations = cur.Rotations
+			next.LastError = cur.LastError
+		}
+		j.setFile(path, &next)
+		j.unsaved++
+		if j.appended+j.unsaved >= j.snapshotEvery {
+			_ = j.snapshot()
+		}
+	}
+	st := j.st.Files[path]
+	st.seenSize = size
+	return *st, change
+}
+
+// Failed records the last error seen while rotating path.
+func (j *Journal) Failed(path string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	next := FileState{FirstSeen: time.Now()}
+	if cur, ok := j.st.Files[path]; ok {
+		next = *cur
+	}
+	next.LastError = err.Error()
+	_ = j.append(logRecord{Kind: "file", Path: path, State: &next})
+}
+
+// Begin persists a new intent and returns it. Callers must not touch the
+// filesystem if Begin fails.
+func (j *Journal) Begin(op, path, target, staging string, mode os.FileMode, size int64) (*Intent, error) {
 	j.mu.Lock()
 	defer j.mu.Unlock()
-	j.st.Files[path] = action
-	_ = j.save()
+	in := &Intent{
+		ID:      strconv.FormatUint(j.st.NextID+1, 10),
+		Op:      op,
+		Path:    path,
+		Target:  target,
+		Staging: staging,
+		Mode:    mode,
+		Size:    size,
+		Phase:   phaseBegun,
+		Started: time.Now(),
+	}
+	if err := j.append(logRecord{Kind: "intent", Intent: in}); err != nil {
+		return nil, err
+	}
+	return j.st.Intents[in.ID], nil
+}
+
+// Advance moves an intent to the given phase.
+func (j *Journal) Advance(in *Intent, phase string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	next := *in
+	next.Phase = phase
+	if err := j.append(logRecord{Kind: "intent", Intent: &next}); err != nil {
+		return err
+	}
+	in.Phase = phase
+	return nil
+}
+
+// Finish drops a completed or rolled back intent. When rotated is set the
+// file's state is updated in the same record: the live file starts over and,
+// for rename, will get a new identity on the next Observe.
+func (j *Journal) Finish(in *Intent, rotated bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := logRecord{Kind: "finish", ID: in.ID, Path: in.Path}
+	if rotated {
+		now := time.Now()
+		next := FileState{FirstSeen: now}
+		if cur, ok := j.st.Files[in.Path]; ok {
+			next = *cur
+		}
+		if in.Op == "rename" {
+			next.Dev, next.Ino = 0, 0
+		}
+		next.FirstSeen = now
+		next.LastRotated = now
+		next.LastSize = in.Size
+		next.Rotations++
+		next.LastError = ""
+		next.seenSize = 0
+		rec.State = &next
+	}
+	_ = j.append(rec)
+	if st, ok := j.st.Files[in.Path]; ok && rotated {
+		st.seenSize = 0
+	}
+}
+
+// Pending returns intents left behind by a previous run.
+func (j *Journal) Pending() []*Intent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]*Intent, 0, len(j.st.Intents))
+	for _, in := range j.st.Intents {
+		out = append(out, in)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Started.Before(out[b].Started) })
+	return out
+}
+
+// Enqueue persists a compression job, replacing any job for the same path.
+func (j *Journal) Enqueue(job CompressJob) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.append(logRecord{Kind: "enqueue", Job: &job})
+}
+
+// Dequeue removes the compression job for path.
+func (j *Journal) Dequeue(path string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.st.Compress[path]; !ok {
+		return
+	}
+	_ = j.append(logRecord{Kind: "dequeue", Path: path})
+}
+
+// QueueLen returns the number of pending compression jobs.
+func (j *Journal) QueueLen() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.st.Compress)
+}
+
+// IsQueued reports whether path is waiting to be compressed; a job that
+// only checksums an archive kept as is does not count.
+func (j *Journal) IsQueued(path string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.st.Compress[path]
+	return ok && !job.Checksum
+}
+
+// Queued returns pending compression jobs ordered by due time.
+func (j *Journal) Queued() []CompressJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make([]CompressJob, 0, len(j.st.Compress))
+	for _, job := range j.st.Compress {
+		out = append(out, *job)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Due.Before(out[b].Due) })
+	return out
+}
+
+// Archived records a compressed archive at path. from names the file it
+// replaced (the uncompressed source or a lower tier), if any.
+func (j *Journal) Archived(path, from string, st ArchiveState) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_ = j.append(logRecord{Kind: "archive", Path: path, From: from, Archive: &st})
+}
+
+// Shipped records that the archive at path was uploaded as key.
+func (j *Journal) Shipped(path, key string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	a, ok := j.st.Archives[path]
+	if !ok {
+		return
+	}
+	next := *a
+	next.Shipped = time.Now()
+	next.Key = key
+	_ = j.append(logRecord{Kind: "archive", Path: path, Archive: &next})
+}
+
+// Archive returns what the journal knows about the archive at path.
+func (j *Journal) Archive(path string) (ArchiveState, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	a, ok := j.st.Archives[path]
+	if !ok {
+		return ArchiveState{}, false
+	}
+	return *a, true
+}
+
+// Moved re-keys the compression job and archive entry of a renamed archive.
+func (j *Journal) Moved(from, to string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, queued := j.st.Compress[from]
+	_, known := j.st.Archives[from]
+	if !queued && !known {
+		return
+	}
+	_ = j.append(logRecord{Kind: "move", Path: to, From: from})
+}
+
+// Archives returns a copy of all known archives keyed by path.
+func (j *Journal) Archives() map[string]ArchiveState {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	out := make(map[string]ArchiveState, len(j.st.Archives))
+	for p, a := range j.st.Archives {
+		out[p] = *a
+	}
+	return out
+}
+
+// Close snapshots the journal and releases the log file.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	err := j.snapshot()
+	if j.log != nil {
+		_ = j.log.Close()
+		j.log = nil
+	}
+	return err
 }
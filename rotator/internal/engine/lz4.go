@@ -0,0 +1,35 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/pierrec/lz4/v4"
+)
+
+func init() { registerCodec(lz4Codec{}) }
+
+// lz4Codec is the low-latency choice. Level 0 is lz4's fast mode; 1-9
+// select the slower high-compression levels.
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string { return "lz4" }
+func (lz4Codec) Ext() string  { return ".lz4" }
+
+func (lz4Codec) CheckLevel(level int) error { return levelIn(level, 1, 9) }
+
+func (lz4Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	zw := lz4.NewWriter(w)
+	if level > 0 {
+		if level > 9 {
+			level = 9
+		}
+		if err := zw.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(1 << (8 + level)))); err != nil {
+			return nil, err
+		}
+	}
+	return zw, nil
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(lz4.NewReader(r)), nil
+}
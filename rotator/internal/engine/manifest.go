@@ -0,0 +1,182 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// manifestName is the file in each archive directory that records what was
+// written there, so archives can be checked long after the fact.
+const manifestName = ".rotator-manifest.json"
+
+// ManifestEntry is what was recorded about one archive when it was written.
+// First and Last bound the time of the data it holds; First is zero when
+// the rotator did not see the file start. SHA256 is empty until a
+// compression worker has checksummed the archive.
+type ManifestEntry struct {
+	SHA256 string    `json:"sha256,omitempty"`
+	Size   int64     `json:"size"`
+	Codec  string    `json:"codec,omitempty"`
+	KeyID  string    `json:"keyId,omitempty"` // encryption key, if encrypted
+	First  time.Time `json:"first"`
+	Last   time.Time `json:"last"`
+}
+
+type manifest struct {
+	Version  int                      `json:"version"`
+	Archives map[string]ManifestEntry `json:"archives"`
+}
+
+// manifestMu serializes read-modify-write of manifests; rotation, the
+// compression workers and budget purges all update them.
+var manifestMu sync.Mutex
+
+func readManifest(dir string) (manifest, error) {
+	m := manifest{Version: 1, Archives: map[string]ManifestEntry{}}
+	b, err := os.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, err
+	}
+	if m.Archives == nil {
+		m.Archives = map[string]ManifestEntry{}
+	}
+	return m, nil
+}
+
+// updateManifest applies fn to dir's manifest and writes it back, removing
+// the file once no archives are left in it.
+func updateManifest(dir string, fn func(map[string]ManifestEntry)) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	m, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	fn(m.Archives)
+	p := filepath.Join(dir, manifestName)
+	if len(m.Archives) == 0 {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, b, 0o644)
+}
+
+// manifestEntry returns what dir's manifest says about path.
+func manifestEntry(path string) (ManifestEntry, bool) {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	m, err := readManifest(filepath.Dir(path))
+	if err != nil {
+		return ManifestEntry{}, false
+	}
+	ent, ok := m.Archives[filepath.Base(path)]
+	return ent, ok
+}
+
+// recordArchive checksums the archive at path and records it as ent,
+// replacing the entry of from (the file it was made from), whose time range
+// it keeps.
+func (e *Engine) recordArchive(path, from string, ent ManifestEntry) {
+	sum, size, err := digestFile(path)
+	if err == nil {
+		if prev, ok := manifestEntry(from); ok && from != "" {
+			ent.First, ent.Last = prev.First, prev.Last
+		}
+		ent.SHA256, ent.Size = sum, size
+		err = updateManifest(filepath.Dir(path), func(a map[string]ManifestEntry) {
+			if from != "" && filepath.Dir(from) == filepath.Dir(path) {
+				delete(a, filepath.Base(from))
+			}
+			a[filepath.Base(path)] = ent
+		})
+	}
+	if err != nil {
+		e.m.CountError("manifest")
+		e.log.WithError(err).WithField("file", path).Warn("failed to record archive checksum")
+	}
+}
+
+// noteArchive records a freshly rotated archive with its size and ent's
+// time range. Rotation does not read the file to checksum it; the
+// compression workers do that, see recordArchive.
+func (e *Engine) noteArchive(path string, ent ManifestEntry) {
+	fi, err := os.Stat(path)
+	if err == nil {
+		ent.Size = fi.Size()
+		err = updateManifest(filepath.Dir(path), func(a map[string]ManifestEntry) {
+			a[filepath.Base(path)] = ent
+		})
+	}
+	if err != nil {
+		e.m.CountError("manifest")
+		e.log.WithError(err).WithField("file", path).Warn("failed to record archive")
+	}
+}
+
+// forgetArchives drops removed archives from their manifests.
+func (e *Engine) forgetArchives(paths ...string) {
+	byDir := map[string][]string{}
+	for _, p := range paths {
+		byDir[filepath.Dir(p)] = append(byDir[filepath.Dir(p)], filepath.Base(p))
+	}
+	for dir, names := range byDir {
+		err := updateManifest(dir, func(a map[string]ManifestEntry) {
+			for _, n := range names {
+				delete(a, n)
+			}
+		})
+		if err != nil {
+			e.m.CountError("manifest")
+			e.log.WithError(err).WithField("dir", dir).Warn("failed to update archive manifest")
+		}
+	}
+}
+
+// movedArchive re-keys the manifest entry of an archive renamed within its
+// directory.
+func (e *Engine) movedArchive(from, to string) {
+	err := updateManifest(filepath.Dir(to), func(a map[string]ManifestEntry) {
+		if ent, ok := a[filepath.Base(from)]; ok {
+			delete(a, filepath.Base(from))
+			a[filepath.Base(to)] = ent
+		}
+	})
+	if err != nil {
+		e.m.CountError("manifest")
+		e.log.WithError(err).WithField("file", to).Warn("failed to update archive manifest")
+	}
+}
+
+// digestFile returns the hex SHA-256 and size of the file at path.
+func digestFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
@@ -0,0 +1,29 @@
+package engine
+
+import (
+	"errors"
+	"os"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// copyOwner gives f the permission bits and owner of fi. An archive made
+// by the daemon would otherwise belong to it with the default mode. Owner
+// changes are skipped when the daemon may not make them.
+func copyOwner(f *os.File, fi os.FileInfo) error {
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if uid, gid, ok := util.FileOwner(fi); ok {
+		if err := f.Chown(uid, gid); err != nil && !errors.Is(err, os.ErrPermission) {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyTimes gives path the access and modification times of fi, so an
+// archive keeps its place in the mtime order retention relies on.
+func copyTimes(path string, fi os.FileInfo) error {
+	return os.Chtimes(path, util.AccessTime(fi), fi.ModTime())
+}
@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// archiveDirFor returns where archives of live go: next to it, or under the
+// policy's archiveDir mirroring live's path below its discovery root. With
+// several roots configured, each has its own directory under archiveDir.
+func (e *Engine) archiveDirFor(live string, pol config.PolicyConfig) (string, error) {
+	dir := filepath.Dir(live)
+	if pol.ArchiveDir == "" {
+		return dir, nil
+	}
+	r := e.rootFor(dir)
+	if r == nil {
+		return "", fmt.Errorf("%s is outside the discovery roots", live)
+	}
+	rel, err := filepath.Rel(r.path, dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(pol.ArchiveDir, r.sub, rel), nil
+}
+
+// archiveRoots returns the discovery roots plus every configured archiveDir.
+func (e *Engine) archiveRoots() []string { return archiveRootsOf(e.cfg) }
+
+func archiveRootsOf(cfg *config.Config) []string {
+	var roots []string
+	for _, r := range cfg.DiscoveryRoots() {
+		roots = append(roots, r.Discovery.Path)
+	}
+	return append(roots, archiveDirsOf(cfg)...)
+}
+
+// archiveDirsOf returns every archiveDir configured in a policy.
+func archiveDirsOf(cfg *config.Config) []string {
+	var dirs []string
+	seen := map[string]bool{}
+	add := func(p *config.PolicyConfig) {
+		if p != nil && p.ArchiveDir != "" && !seen[p.ArchiveDir] {
+			seen[p.ArchiveDir] = true
+			dirs = append(dirs, p.ArchiveDir)
+		}
+	}
+	add(&cfg.Defaults.Policy)
+	for _, r := range cfg.Roots {
+		add(r.Policy)
+	}
+	for _, ns := range cfg.Overrides.Namespaces {
+		add(ns.Policy)
+	}
+	for _, p := range cfg.Overrides.Paths {
+		add(p.Policy)
+	}
+	return dirs
+}
+
+// sameDevice reports whether two directories are on the same filesystem.
+func sameDevice(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	ad, _ := util.FileID(ai)
+	bd, _ := util.FileID(bi)
+	return ad == bd
+}
+
+// stagingName is the hidden name a file is renamed to before it is moved to
+// another filesystem.
+func stagingName(live string) string {
+	return filepath.Join(filepath.Dir(live), "."+filepath.Base(live)+".rotating")
+}
+
+// moveFile renames src to dst, copying through a temp file in dst's
+// directory when they are on different filesystems.
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := copyOwner(tmp, fi); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := copyTimes(tmp.Name(), fi); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
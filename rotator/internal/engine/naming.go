@@ -0,0 +1,346 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/crypt"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// Archive naming schemes. "numeric" (the default) takes the first free
+// suffix: file.log.1, .2, ... "shift" renumbers existing archives so .1 is
+// always the newest, like logrotate. Anything else is a template such as
+// "{base}-{yyyyMMdd-HHmmss}{ext}".
+const (
+	namingNumeric = "numeric"
+	namingShift   = "shift"
+)
+
+// maxArchiveIndex bounds the search for a free archive name.
+const maxArchiveIndex = 1000
+
+// archiveNamer renders and recognizes archive names for one scheme.
+//
+// Template placeholders:
+//
+//	{name}       live file name (app.log)
+//	{base}       name without its extension (app)
+//	{ext}        extension including the dot (.log)
+//	{n}          1, 2, ...: first free index, or 1 after shifting
+//	{hostname}   NODE_NAME, else the hostname
+//	{namespace}  {pod}  {container}
+//	{yyyyMMdd-HHmmss} and similar date patterns (y M d H m s), in UTC
+//
+// Templates without {n} get a ".N" suffix when the rendered name is taken.
+type archiveNamer struct {
+	shift bool
+	parts []namePart
+	hasN  bool
+
+	matchers sync.Map // live file name -> *regexp.Regexp
+}
+
+type namePart struct {
+	lit    string
+	field  string
+	layout string // Go time layout, for date fields
+}
+
+// nameVars are the per-file values substituted into a template.
+type nameVars struct {
+	Namespace string
+	Pod       string
+	Container string
+	Time      time.Time
+}
+
+var namers sync.Map // scheme -> *archiveNamer
+
+// namerFor returns the parsed namer for a policy's ArchiveName.
+func namerFor(scheme string) (*archiveNamer, error) {
+	if v, ok := namers.Load(scheme); ok {
+		return v.(*archiveNamer), nil
+	}
+	n, err := parseArchiveName(scheme)
+	if err != nil {
+		return nil, err
+	}
+	namers.Store(scheme, n)
+	return n, nil
+}
+
+func parseArchiveName(scheme string) (*archiveNamer, error) {
+	tmpl := scheme
+	shift := false
+	switch scheme {
+	case "", namingNumeric:
+		tmpl = "{name}.{n}"
+	case namingShift:
+		tmpl, shift = "{name}.{n}", true
+	}
+	if strings.ContainsRune(tmpl, '/') {
+		return nil, fmt.Errorf("archive name %q must not contain '/'", scheme)
+	}
+	n := &archiveNamer{shift: shift}
+	rest := tmpl
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			n.parts = append(n.parts, namePart{lit: rest})
+			break
+		}
+		if open > 0 {
+			n.parts = append(n.parts, namePart{lit: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("archive name %q: unclosed '{'", scheme)
+		}
+		field := rest[open+1 : open+end]
+		rest = rest[open+end+1:]
+		switch field {
+		case "name", "base", "ext", "hostname", "namespace", "pod", "container":
+			n.parts = append(n.parts, namePart{field: field})
+		case "n":
+			n.hasN = true
+			n.parts = append(n.parts, namePart{field: field})
+		default:
+			layout, ok := dateLayout(field)
+			if !ok {
+				return nil, fmt.Errorf("archive name %q: unknown placeholder {%s}", scheme, field)
+			}
+			n.parts = append(n.parts, namePart{field: "date", layout: layout})
+		}
+	}
+	if !n.hasN && !n.hasDate() {
+		return nil, fmt.Errorf("archive name %q needs {n} or a date placeholder", scheme)
+	}
+	return n, nil
+}
+
+func (n *archiveNamer) hasDate() bool {
+	for _, p := range n.parts {
+		if p.field == "date" {
+			return true
+		}
+	}
+	return false
+}
+
+// dateLayout converts a yyyyMMdd-HHmmss style pattern to a Go layout.
+func dateLayout(field string) (string, bool) {
+	if strings.Trim(field, "yMdHms-_.:") != "" || strings.Trim(field, "-_.:") == "" {
+		return "", false
+	}
+	r := strings.NewReplacer("yyyy", "2006", "yy", "06", "MM", "01", "dd", "02", "HH", "15", "mm", "04", "ss", "05")
+	layout := r.Replace(field)
+	if strings.ContainsAny(layout, "yMdHms") {
+		return "", false
+	}
+	return layout, true
+}
+
+func splitName(live string) (name, base, ext string) {
+	name = filepath.Base(live)
+	ext = filepath.Ext(name)
+	return name, strings.TrimSuffix(name, ext), ext
+}
+
+// render names an archive of live inside dir.
+func (n *archiveNamer) render(dir, live string, v nameVars, idx int) string {
+	name, base, ext := splitName(live)
+	var b strings.Builder
+	for _, p := range n.parts {
+		switch p.field {
+		case "":
+			b.WriteString(p.lit)
+		case "name":
+			b.WriteString(name)
+		case "base":
+			b.WriteString(base)
+		case "ext":
+			b.WriteString(ext)
+		case "n":
+			b.WriteString(strconv.Itoa(idx))
+		case "hostname":
+			b.WriteString(util.NodeName())
+		case "namespace":
+			b.WriteString(v.Namespace)
+		case "pod":
+			b.WriteString(v.Pod)
+		case "container":
+			b.WriteString(v.Container)
+		case "date":
+			b.WriteString(v.Time.UTC().Format(p.layout))
+		}
+	}
+	return filepath.Join(dir, b.String())
+}
+
+// next returns a free archive path for live in dir. In shift mode the
+// caller must have shifted existing archives first.
+func (n *archiveNamer) next(dir, live string, v nameVars) (string, error) {
+	if n.shift {
+		return n.render(dir, live, v, 1), nil
+	}
+	if n.hasN {
+		for i := 1; i <= maxArchiveIndex; i++ {
+			if c := n.render(dir, live, v, i); !archiveTaken(c) {
+				return c, nil
+			}
+		}
+		return "", fmt.Errorf("too many rotations for %s", live)
+	}
+	c := n.render(dir, live, v, 0)
+	if !archiveTaken(c) {
+		return c, nil
+	}
+	for i := 1; i <= maxArchiveIndex; i++ {
+		if u := c + "." + strconv.Itoa(i); !archiveTaken(u) {
+			return u, nil
+		}
+	}
+	return "", fmt.Errorf("too many rotations for %s", live)
+}
+
+// archiveTaken reports whether p exists plain or in any compressed or
+// encrypted form.
+func archiveTaken(p string) bool {
+	if util.FileExists(p) || util.FileExists(p+crypt.Ext) {
+		return true
+	}
+	for _, c := range codecs {
+		if util.FileExists(p+c.Ext()) || util.FileExists(p+c.Ext()+crypt.Ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// matcher returns a regexp matching the names (without compression
+// extension) of archives of live. It depends only on live's file name and
+// is compiled once per name.
+func (n *archiveNamer) matcher(live string) *regexp.Regexp {
+	name, base, ext := splitName(live)
+	if v, ok := n.matchers.Load(name); ok {
+		return v.(*regexp.Regexp)
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for _, p := range n.parts {
+		switch p.field {
+		case "":
+			b.WriteString(regexp.QuoteMeta(p.lit))
+		case "name":
+			b.WriteString(regexp.QuoteMeta(name))
+		case "base":
+			b.WriteString(regexp.QuoteMeta(base))
+		case "ext":
+			b.WriteString(regexp.QuoteMeta(ext))
+		case "n":
+			b.WriteString(`(\d+)`)
+		case "hostname", "namespace", "pod", "container":
+			b.WriteString(`[^/]+`)
+		case "date":
+			for _, r := range p.layout {
+				if r >= '0' && r <= '9' {
+					b.WriteString(`\d`)
+				} else {
+					b.WriteString(regexp.QuoteMeta(string(r)))
+				}
+			}
+		}
+	}
+	if !n.hasN {
+		b.WriteString(`(?:\.\d+)?`)
+	}
+	b.WriteString("$")
+	re := regexp.MustCompile(b.String())
+	n.matchers.Store(name, re)
+	return re
+}
+
+// matchesArchive reports whether path, compressed or not, matches a
+// matcher built for some live file.
+func matchesArchive(re *regexp.Regexp, path string) bool {
+	return re.MatchString(trimCodecExt(filepath.Base(path)))
+}
+
+// archiveOfSibling reports whether path is an archive of another file in
+// its directory. Templates that keep the extension, such as
+// "{base}-{yyyyMMdd-HHmmss}{ext}", name archives the discovery includes
+// match as well as the live file.
+func (n *archiveNamer) archiveOfSibling(path string) bool {
+	dir := filepath.Dir(path)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, ent := range entries {
+		if !ent.Type().IsRegular() || ent.Name() == filepath.Base(path) {
+			continue
+		}
+		if _, compressed := codecByExt(ent.Name()); compressed || isEncrypted(ent.Name()) {
+			continue
+		}
+		if matchesArchive(n.matcher(filepath.Join(dir, ent.Name())), path) {
+			return true
+		}
+	}
+	return false
+}
+
+// shiftArchives renames live's numbered archives in dir up by one, highest
+// first, so the next rotation can take .1. Archives a compression worker is
+// busy with cannot be renamed and make the shift fail before anything moves.
+func (e *Engine) shiftArchives(dir, live string, n *archiveNamer) error {
+	re := n.matcher(live)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	type item struct {
+		path, ext string
+		idx       int
+	}
+	var items []item
+	for _, ent := range entries {
+		if ent.IsDir() {
+			continue
+		}
+		stem := trimCodecExt(ent.Name())
+		m := re.FindStringSubmatch(stem)
+		if m == nil {
+			continue
+		}
+		idx, _ := strconv.Atoi(m[1])
+		items = append(items, item{path: filepath.Join(dir, ent.Name()), ext: ent.Name()[len(stem):], idx: idx})
+	}
+	sort.Slice(items, func(a, b int) bool { return items[a].idx > items[b].idx })
+	paths := make([]string, len(items))
+	for i, it := range items {
+		paths[i] = it.path
+	}
+	release, ok := e.cq.claim(paths)
+	if !ok {
+		return fmt.Errorf("archives of %s are being compressed; shift deferred", live)
+	}
+	defer release()
+	for _, it := range items {
+		to := n.render(dir, live, nameVars{}, it.idx+1) + it.ext
+		if err := os.Rename(it.path, to); err != nil {
+			return err
+		}
+		e.jrnl.Moved(it.path, to)
+		e.movedArchive(it.path, to)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"os"
+)
+
+// Recovery outcomes, also used as metric label values.
+const (
+	outcomeCompleted  = "completed"
+	outcomeRolledBack = "rolled_back"
+	outcomeFailed     = "failed"
+)
+
+// recoverIntents replays rotations that a previous run began but never
+// finished, e.g. because the process was OOM-killed mid-copy.
+func (e *Engine) recoverIntents() {
+	for _, in := range e.jrnl.Pending() {
+		outcome := e.resolveIntent(in)
+		e.m.RecoveredIntents.WithLabelValues(in.Op, outcome).Inc()
+		e.log.WithFields(map[string]interface{}{
+			"file":    in.Path,
+			"target":  in.Target,
+			"op":      in.Op,
+			"phase":   in.Phase,
+			"outcome": outcome,
+		}).Warn("recovered interrupted rotation")
+	}
+}
+
+// resolveIntent finishes or rolls back an intent based on what is on disk.
+// Failed intents stay in the journal and are retried on the next start.
+func (e *Engine) resolveIntent(in *Intent) string {
+	var outcome string
+	switch in.Op {
+	case "copytruncate", "trim":
+		outcome = resolveCopyTruncate(in)
+	default:
+		outcome = resolveRename(in)
+	}
+	switch outcome {
+	case outcomeCompleted:
+		e.jrnl.Finish(in, true)
+	case outcomeRolledBack:
+		e.jrnl.Finish(in, false)
+	}
+	return outcome
+}
+
+func resolveRename(in *Intent) string {
+	_, liveErr := os.Stat(in.Path)
+	if in.Staging != "" {
+		if _, err := os.Stat(in.Staging); err == nil {
+			// renamed aside but not yet moved to the archive filesystem;
+			// a partial copy at the target is replaced by moveFile
+			if os.IsNotExist(liveErr) && !recreate(in) {
+				return outcomeFailed
+			}
+			if err := moveFile(in.Staging, in.Target); err != nil {
+				return outcomeFailed
+			}
+			return outcomeCompleted
+		}
+	}
+	_, archErr := os.Stat(in.Target)
+	switch {
+	case os.IsNotExist(archErr):
+		// rename never happened; the live file is untouched
+		return outcomeRolledBack
+	case archErr != nil:
+		return outcomeFailed
+	case os.IsNotExist(liveErr):
+		// renamed but the live file was never recreated
+		if !recreate(in) {
+			return outcomeFailed
+		}
+		return outcomeCompleted
+	default:
+		return outcomeCompleted
+	}
+}
+
+func recreate(in *Intent) bool {
+	f, err := os.OpenFile(in.Path, os.O_CREATE|os.O_WRONLY, in.Mode.Perm())
+	if err != nil {
+		return false
+	}
+	_ = f.Close()
+	return true
+}
+
+// resolveCopyTruncate also covers trim, which copies the dropped lines out
+// before changing the live file in place.
+func resolveCopyTruncate(in *Intent) string {
+	if in.Target == "" {
+		// trim without an archive: a partial trim left whole lines behind
+		return outcomeRolledBack
+	}
+	if in.Phase != phaseCopied {
+		// the copy may be partial and the live file still holds every byte
+		if err := os.Remove(in.Target); err != nil && !os.IsNotExist(err) {
+			return outcomeFailed
+		}
+		return outcomeRolledBack
+	}
+	// The archive is complete. Whether the truncate ran is unknown, and the
+	// live file may have taken new writes since, so it is left alone: a
+	// duplicated tail is preferable to lost lines.
+	return outcomeCompleted
+}
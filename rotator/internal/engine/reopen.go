@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/procfs"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// Reopen results, also used as metric label values.
+const (
+	reopenDone      = "reopened"   // the new file started growing
+	reopenStale     = "stale"      // the writer kept writing to the archive
+	reopenIdle      = "idle"       // nothing was written before the deadline
+	reopenNoProcess = "no_process" // nobody to signal
+	reopenError     = "error"
+)
+
+const (
+	defaultReopenSignal = "HUP"
+	defaultReopenVerify = 30 * time.Second
+	reopenPoll          = 250 * time.Millisecond
+)
+
+// signalWriters tells the processes writing to a just renamed file to reopen
+// it, then checks in the background that they did. old is the live file as
+// it was before the rename and archived the bytes it held.
+func (e *Engine) signalWriters(ctx context.Context, f discover.FileInfo, rc *config.ReopenConfig, old os.FileInfo, archive string, archived int64) {
+	name := rc.Signal
+	if name == "" {
+		name = defaultReopenSignal
+	}
+	sig, err := lookupSignal(name)
+	if err != nil {
+		e.reopenResult(f, reopenError, err)
+		return
+	}
+	pids, err := reopenPIDs(rc, old)
+	if err != nil {
+		e.reopenResult(f, reopenError, err)
+		return
+	}
+	if len(pids) == 0 {
+		e.reopenResult(f, reopenNoProcess, nil)
+		return
+	}
+	for _, pid := range pids {
+		p, err := os.FindProcess(pid)
+		if err == nil {
+			err = p.Signal(sig)
+		}
+		if err != nil {
+			e.reopenResult(f, reopenError, fmt.Errorf("signal pid %d: %w", pid, err))
+			return
+		}
+	}
+	verify := rc.Verify
+	if verify <= 0 {
+		verify = defaultReopenVerify
+	}
+	e.bg.Add(1)
+	go func() {
+		defer e.bg.Done()
+		e.reopenResult(f, verifyReopen(ctx, f.Path, archive, archived, verify), nil)
+	}()
+}
+
+// reopenPIDs returns the pid from the pidfile, or else every process with
+// the old inode open for writing.
+func reopenPIDs(rc *config.ReopenConfig, old os.FileInfo) ([]int, error) {
+	if rc.PIDFile != "" {
+		b, err := os.ReadFile(rc.PIDFile)
+		if err != nil {
+			return nil, err
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, fmt.Errorf("pidfile %s: %w", rc.PIDFile, err)
+		}
+		return []int{pid}, nil
+	}
+	dev, ino := util.FileID(old)
+	holders, err := procfs.Holders(procfs.Root, dev, ino)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{}
+	var pids []int
+	for _, h := range holders {
+		if h.Write && !seen[h.PID] {
+			seen[h.PID] = true
+			pids = append(pids, h.PID)
+		}
+	}
+	return pids, nil
+}
+
+// verifyReopen waits until the live file grows, the archive grows instead,
+// or the deadline passes.
+func verifyReopen(ctx context.Context, live, archive string, archived int64, within time.Duration) string {
+	deadline := time.NewTimer(within)
+	defer deadline.Stop()
+	tick := time.NewTicker(reopenPoll)
+	defer tick.Stop()
+	stale := false
+	for {
+		select {
+		case <-ctx.Done():
+			return reopenIdle
+		case <-deadline.C:
+			if stale {
+				return reopenStale
+			}
+			return reopenIdle
+		case <-tick.C:
+		}
+		if fi, err := os.Stat(live); err == nil && fi.Size() > 0 {
+			return reopenDone
+		}
+		if fi, err := os.Stat(archive); err == nil && fi.Size() > archived {
+			// keep waiting: the signal may still be in flight
+			stale = true
+		}
+	}
+}
+
+func (e *Engine) reopenResult(f discover.FileInfo, result string, err error) {
+	e.m.Reopens.WithLabelValues(f.Group, result).Inc()
+	switch result {
+	case reopenStale:
+		e.log.WithField("file", f.Path).Warn("writer did not reopen its log after rotation")
+	case reopenError:
+		e.log.WithError(err).WithField("file", f.Path).Warn("failed to signal writers to reopen")
+	}
+}
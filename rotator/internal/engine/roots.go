@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/pkg/budget"
+)
+
+// rootState is a discovery root as the engine sees it: where its live files
+// are, how their namespace is read and how much archive space each of its
+// namespaces may use.
+type rootState struct {
+	name   string
+	path   string
+	sub    string // below each archiveDir; empty for the single default root
+	layout *discover.Layout
+	host   bool // keyed by path overrides alone
+	limit  int64
+	bud    *budget.Tracker
+}
+
+// newRoots resolves the configured discovery roots. Names must be unique
+// and roots must not nest, so every file belongs to exactly one of them.
+func newRoots(cfg *config.Config) ([]*rootState, error) {
+	var roots []*rootState
+	names := map[string]bool{}
+	for _, rc := range cfg.DiscoveryRoots() {
+		if rc.Name == "" || names[rc.Name] {
+			return nil, fmt.Errorf("discovery roots need unique names (%q)", rc.Name)
+		}
+		names[rc.Name] = true
+		if len(cfg.Roots) > 0 && rc.Discovery.Path == "" {
+			return nil, fmt.Errorf("discovery root %s has no path", rc.Name)
+		}
+		lay, err := discover.ParseLayout(rc.Discovery)
+		if err != nil {
+			return nil, fmt.Errorf("discovery root %s: %w", rc.Name, err)
+		}
+		limit := cfg.Defaults.Budgets.PerNamespaceBytes
+		if rc.Budgets != nil && rc.Budgets.PerNamespaceBytes != 0 {
+			limit = rc.Budgets.PerNamespaceBytes
+		}
+		r := &rootState{
+			name:   rc.Name,
+			path:   filepath.Clean(rc.Discovery.Path),
+			layout: lay,
+			host:   rc.Discovery.Mode == config.ModeHost,
+			limit:  int64(limit),
+			bud:    budget.New(int64(limit)),
+		}
+		if len(cfg.Roots) > 0 {
+			// roots sharing an archiveDir keep their archives apart
+			r.sub = rc.Name
+		}
+		for _, o := range roots {
+			if within(o.path, r.path) || within(r.path, o.path) {
+				return nil, fmt.Errorf("discovery roots %s and %s overlap", o.name, r.name)
+			}
+		}
+		roots = append(roots, r)
+	}
+	return roots, nil
+}
+
+// rootFor returns the root live lies in, or nil.
+func (e *Engine) rootFor(live string) *rootState {
+	for _, r := range e.roots {
+		if within(r.path, live) {
+			return r
+		}
+	}
+	return nil
+}
+
+// within reports whether path is dir or below it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(filepath.ToSlash(rel), "../")
+}
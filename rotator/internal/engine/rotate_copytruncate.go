@@ -1,46 +1,199 @@
 package engine
 
 import (
-	"fmt"
+	"bytes"
 	"io"
 	"os"
+	"time"
 )
 
-func rotateByCopyTruncate(path string) (string, int64, error) {
-	// copy to next available suffix, then truncate original
-	var next int = 1
-	for {
-		candidate := fmt.Sprintf("%s.%d", path, next)
-		if _, err := os.Stat(candidate); os.IsNotExist(err) {
-			break
-		}
-		next++
-		if next > 1000 {
-			return "", 0, fmt.Errorf("too many rotations for %s", path)
-		}
-	}
-	target := fmt.Sprintf("%s.%d", path, next)
-	in, err := os.Open(path)
+// catchUpPasses bounds how often the copy goes back for bytes the writer
+// appended while it ran.
+const catchUpPasses = 3
+
+// Copy strategies, cheapest first; also used as metric label values.
+const (
+	copyReflink   = "reflink"
+	copyKernel    = "copy_file_range"
+	copyUserspace = "userspace"
+)
+
+// truncateResult describes a finished copytruncate.
+type truncateResult struct {
+	copied   int64 // bytes in the archive
+	tail     int64 // unfinished last line written back to the live file
+	lost     int64 // bytes written after the last read and before the truncate
+	strategy string
+	took     time.Duration
+}
+
+// rotateByCopyTruncate copies path into target, calls onCopied once the copy
+// is durable, then truncates the original. Bytes appended during the copy
+// are picked up before truncating. With lineBoundary the archive ends at the
+// last newline and the unfinished line is written back to the live file, so
+// no line is split between the two. The copy is a reflink or done in the
+// kernel where the filesystem allows.
+//
+// The writer can still append between the final read and the truncate; such
+// bytes are lost and reported in the result. The unfinished line is put back
+// at the start of the file before it shrinks, so what the writer appends
+// afterwards always follows it.
+func rotateByCopyTruncate(path, target string, lineBoundary bool, onCopied func() error) (truncateResult, error) {
+	var res truncateResult
+	in, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
-		return "", 0, err
+		return res, err
 	}
 	defer in.Close()
 	fi, err := in.Stat()
 	if err != nil {
-		return "", 0, err
+		return res, err
 	}
-	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode())
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fi.Mode())
 	if err != nil {
-		return "", 0, err
+		return res, err
 	}
-	if _, err := io.Copy(out, in); err != nil {
+	start := time.Now()
+	n, strategy, err := copyCatchUp(in, out)
+	res.strategy, res.took = strategy, time.Since(start)
+	if err != nil {
 		out.Close()
-		return "", 0, err
+		return res, err
 	}
-	_ = out.Close()
-	// truncate source
-	if err := os.Truncate(path, 0); err != nil {
-		return "", 0, err
+	cut := n
+	if lineBoundary {
+		nl, err := lastNewline(out, n)
+		if err != nil {
+			out.Close()
+			return res, err
+		}
+		if nl >= 0 {
+			cut = nl + 1
+		}
+	}
+	if cut < n {
+		if err := out.Truncate(cut); err != nil {
+			out.Close()
+			return res, err
+		}
+	}
+	// the archive takes the live file's owner, mode and times as of the copy
+	copied, err := in.Stat()
+	if err != nil {
+		out.Close()
+		return res, err
+	}
+	if err := copyOwner(out, copied); err != nil {
+		out.Close()
+		return res, err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return res, err
+	}
+	if err := out.Close(); err != nil {
+		return res, err
+	}
+	if err := copyTimes(target, copied); err != nil {
+		return res, err
+	}
+	if err := onCopied(); err != nil {
+		return res, err
+	}
+	res.copied = cut
+
+	var tail []byte
+	end := n
+	if lineBoundary {
+		// everything after the cut, including lines written since the copy
+		if tail, err = readFrom(in, cut); err != nil {
+			return res, err
+		}
+		end = cut + int64(len(tail))
+	}
+	if len(tail) > 0 {
+		// the file is still at least end bytes long, so this only overwrites
+		// data already archived and appends still land after it
+		if _, err := in.WriteAt(tail, 0); err != nil {
+			return res, err
+		}
+	}
+	if fi, err = in.Stat(); err != nil {
+		return res, err
+	}
+	if fi.Size() > end {
+		res.lost = fi.Size() - end
+	}
+	if err := in.Truncate(int64(len(tail))); err != nil {
+		return res, err
+	}
+	res.tail = int64(len(tail))
+	return res, nil
+}
+
+// copyCatchUp copies in to out until in stops growing or catchUpPasses is
+// reached, and returns the number of bytes copied and how the bulk of them
+// was copied.
+func copyCatchUp(in, out *os.File) (int64, string, error) {
+	var total int64
+	strategy := ""
+	if n, ok := cloneFile(out, in); ok {
+		total, strategy = n, copyReflink
+	}
+	for pass := 0; pass <= catchUpPasses; pass++ {
+		n, s, err := copyData(out, in)
+		total += n
+		if strategy == "" {
+			strategy = s
+		}
+		if err != nil {
+			return total, strategy, err
+		}
+		fi, err := in.Stat()
+		if err != nil {
+			return total, strategy, err
+		}
+		if fi.Size() <= total {
+			break
+		}
+	}
+	return total, strategy, nil
+}
+
+// copyData copies the rest of src to dst, in the kernel when possible.
+func copyData(dst, src *os.File) (int64, string, error) {
+	if n, ok, err := copyRange(dst, src); ok {
+		return n, copyKernel, err
+	}
+	// hide the *os.File types so io.Copy does not try the kernel again
+	n, err := io.Copy(struct{ io.Writer }{dst}, struct{ io.Reader }{src})
+	return n, copyUserspace, err
+}
+
+// lastNewline returns the offset of the last '\n' in the first size bytes
+// of f, or -1, reading backwards so only the last line is read.
+func lastNewline(f *os.File, size int64) (int64, error) {
+	buf := make([]byte, 64<<10)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return -1, err
+		}
+		if i := bytes.LastIndexByte(chunk, '\n'); i >= 0 {
+			return start + int64(i), nil
+		}
+		end = start
+	}
+	return -1, nil
+}
+
+func readFrom(f *os.File, off int64) ([]byte, error) {
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return nil, err
 	}
-	return target, fi.Size(), nil
+	return io.ReadAll(f)
 }
@@ -1,157 +1,119 @@
 package engine
 
 import (
-	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
 
-func rotateByRename(path string) (string, int64, error) {
-	// Determine next index suffix .1, .2, ... by scanning existing files
-	var next int = 1
-	for {
-		candidate := fmt.Sprintf("%s.%d", path, next)
-		if _, err := os.Stat(candidate); os.IsNotExist(err) {
-			break
-		}
-		next++
-		if next > 1000 { // safety
-			return "", 0, fmt.Errorf("too many rotations for %s", path)
-		}
-	}
-	target := fmt.Sprintf("%s.%d", path, next)
+// rotateByRename renames path to target and recreates path. When target is
+// on another filesystem the file is first renamed to staging next to path,
+// so the live file is swapped atomically, and then moved across.
+func rotateByRename(path, target, staging string) (int64, error) {
 	fi, err := os.Stat(path)
 	if err != nil {
-		return "", 0, err
+		return 0, err
 	}
 	size := fi.Size()
-	if err := os.Rename(path, target); err != nil {
-		return "", 0, err
+	first := target
+	if staging != "" {
+		first = staging
+	}
+	if err := os.Rename(path, first); err != nil {
+		return 0, err
 	}
 	// recreate source file with same mode
 	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode())
 	if err != nil {
-		return "", 0, err
+		return 0, err
 	}
 	_ = f.Close()
-	return target, size, nil
-}
-
-func compressGzip(src string) (string, error) {
-	gz := src + ".gz"
-	in, err := os.Open(src)
-	if err != nil {
-		return "", err
-	}
-	defer in.Close()
-	out, err := os.Create(gz)
-	if err != nil {
-		return "", err
-	}
-	defer out.Close()
-	// use stdlib writer to avoid external deps
-	zw, err := newGzipWriter(out)
-	if err != nil {
-		return "", err
-	}
-	if _, err := io.Copy(zw, in); err != nil {
-		_ = zw.Close()
-		return "", err
-	}
-	if err := zw.Close(); err != nil {
-		return "", err
-	}
-	if err := os.Remove(src); err != nil {
-		return "", err
+	if staging != "" {
+		if err := moveFile(staging, target); err != nil {
+			return 0, err
+		}
 	}
-	return gz, nil
+	return size, nil
 }
 
-// enforceRetention removes files older than keepDays or exceeding keepFiles
-func enforceRetention(base string, keepFiles int, keepDays int) error {
-	dir := filepath.Dir(base)
-	name := filepath.Base(base)
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		return err
-	}
+// enforceRetention removes archives of base in dir older than keepDays, then
+// the oldest beyond keepFiles, and returns the removed paths. Archives are
+// recognized by the policy's naming scheme, compressed or not; those
+// canDelete refuses (not shipped yet) are kept.
+func enforceRetention(dir, base string, n *archiveNamer, keepFiles int, keepDays int, canDelete func(string, time.Time) bool) ([]string, error) {
+	re := n.matcher(base)
 	type item struct {
 		path string
 		mod  time.Time
 	}
-	var rotated []item
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
+	list := func() ([]item, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
 		}
-		p := filepath.Join(dir, e.Name())
-		if !matchesPrefix(p, base) {
-			continue
+		var rotated []item
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			p := filepath.Join(dir, e.Name())
+			if p == base || !matchesArchive(re, p) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			rotated = append(rotated, item{path: p, mod: info.ModTime()})
 		}
-		info, _ := e.Info()
-		rotated = append(rotated, item{path: p, mod: info.ModTime()})
-		_ = name // avoid unused if matchesPrefix changes
+		return rotated, nil
+	}
+	var removed []string
+	rotated, err := list()
+	if err != nil {
+		return removed, err
 	}
 	// remove by age
 	if keepDays > 0 {
 		cutoff := time.Now().Add(-time.Duration(keepDays) * 24 * time.Hour)
 		for _, it := range rotated {
-			if it.mod.Before(cutoff) {
-				_ = os.Remove(it.path)
+			if it.mod.Before(cutoff) && canDelete(it.path, it.mod) && os.Remove(it.path) == nil {
+				removed = append(removed, it.path)
 			}
 		}
 	}
-	// remove by count (simple: rescan and delete oldest beyond keepFiles)
+	// remove by count (rescan and delete oldest beyond keepFiles)
 	if keepFiles > 0 {
-		// refresh list
-		entries, err = os.ReadDir(dir)
+		rotated, err = list()
 		if err != nil {
-			return err
+			return removed, err
 		}
-		rotated = rotated[:0]
-		for _, e := range entries {
-			if e.IsDir() {
-				continue
-			}
-			p := filepath.Join(dir, e.Name())
-			if !matchesPrefix(p, base) {
-				continue
+		sort.Slice(rotated, func(i, j int) bool { return rotated[i].mod.Before(rotated[j].mod) })
+		excess := len(rotated) - keepFiles
+		for _, it := range rotated {
+			if excess <= 0 {
+				break
 			}
-			info, _ := e.Info()
-			rotated = append(rotated, item{path: p, mod: info.ModTime()})
-		}
-		// sort by modtime asc
-		for i := 0; i < len(rotated); i++ {
-			for j := i + 1; j < len(rotated); j++ {
-				if rotated[i].mod.After(rotated[j].mod) {
-					rotated[i], rotated[j] = rotated[j], rotated[i]
-				}
+			if canDelete(it.path, it.mod) && os.Remove(it.path) == nil {
+				removed = append(removed, it.path)
+				excess--
 			}
 		}
-		for len(rotated) > keepFiles {
-			it := rotated[0]
-			_ = os.Remove(it.path)
-			rotated = rotated[1:]
-		}
 	}
-	return nil
+	return removed, nil
 }
 
+// matchesPrefix recognizes the legacy numeric scheme (base.N, optionally
+// compressed). It is used for archives whose live file is gone.
 func matchesPrefix(path, base string) bool {
-	// match files that start with base and have numeric suffix (optionally .gz)
 	bp := filepath.Base(base)
 	p := filepath.Base(path)
 	if !strings.HasPrefix(p, bp+".") {
 		return false
 	}
-	rest := p[len(bp)+1:]
-	// strip .gz
-	if strings.HasSuffix(rest, ".gz") {
-		rest = rest[:len(rest)-3]
-	}
+	rest := trimCodecExt(p[len(bp)+1:])
 	if len(rest) == 0 {
 		return false
 	}
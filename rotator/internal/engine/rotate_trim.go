@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Trim methods. collapse removes whole blocks from the head so the file
+// shrinks; punch frees them but keeps the size and offsets, which is what
+// writers without O_APPEND need.
+const (
+	trimCollapse = "collapse"
+	trimPunch    = "punch"
+)
+
+var errTrimUnsupported = errors.New("trim is not supported on this platform")
+
+// rotateByTrim drops the oldest bytes of path in place, keeping at least the
+// last keep bytes. The cut is at a line boundary: collapse removes whole
+// blocks up to it and zeroes the remainder, punch zeroes everything before
+// it. When target is set the dropped lines are copied there first and
+// onCopied is called once the copy is durable. It returns the number of bytes
+// dropped and the method used; collapse falls back to punch where the
+// filesystem cannot collapse. An error with bytes dropped means only zeroing
+// the rest of the first block after a collapse failed.
+func rotateByTrim(path, target string, keep int64, method string, onCopied func() error) (int64, string, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, "", err
+	}
+	// earlier punches leave a hole at the head; only data after it counts
+	start := dataStart(f)
+	if fi.Size()-start <= keep {
+		return 0, "", nil
+	}
+	nl, err := lastNewline(f, fi.Size()-keep)
+	if err != nil || nl < start {
+		return 0, "", err
+	}
+	cut := nl + 1
+
+	if target != "" {
+		if err := copyRegion(f, target, start, cut-start, fi); err != nil {
+			return 0, "", err
+		}
+		if err := onCopied(); err != nil {
+			return 0, "", err
+		}
+	}
+
+	if method != trimPunch {
+		collapsed, err := collapseHead(f, cut)
+		switch {
+		case err == nil:
+			// zero what is left of the dropped lines in the first block
+			if rest := cut - collapsed; rest > 0 {
+				if err := punchHole(f, 0, rest); err != nil {
+					return cut - start, trimCollapse, err
+				}
+			}
+			return cut - start, trimCollapse, nil
+		case !unsupported(err):
+			return 0, "", err
+		}
+	}
+	if err := punchHole(f, start, cut-start); err != nil {
+		return 0, "", err
+	}
+	return cut - start, trimPunch, nil
+}
+
+// headHole returns the size of the hole left at the head of path by earlier
+// trims, so size triggers see only the data.
+func headHole(path string) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	return dataStart(f)
+}
+
+// copyRegion writes n bytes of f starting at off to a new file at target,
+// owned like f (described by fi).
+func copyRegion(f *os.File, target string, off, n int64, fi os.FileInfo) error {
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(f, off, n)); err != nil {
+		out.Close()
+		return err
+	}
+	if err := copyOwner(out, fi); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,80 @@
+package engine
+
+import (
+	"context"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/ship"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// shipInterval is how often unshipped archives are looked for, besides
+// right after each compression.
+const shipInterval = 30 * time.Second
+
+// shipper uploads compressed archives the journal has not seen shipped.
+type shipper struct {
+	e      *Engine
+	client *ship.Client
+	wake   chan struct{}
+}
+
+func (s *shipper) run(ctx context.Context) {
+	t := time.NewTicker(shipInterval)
+	defer t.Stop()
+	for {
+		s.sweep(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// nudge asks for a sweep soon, e.g. after an archive was compressed.
+func (s *shipper) nudge() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *shipper) sweep(ctx context.Context) {
+	for p, a := range s.e.jrnl.Archives() {
+		if ctx.Err() != nil {
+			return
+		}
+		if !a.Shipped.IsZero() {
+			continue
+		}
+		if a.KeyID == "" && s.e.encryptionFor(a.Namespace, a.Base) != nil {
+			// never upload what is about to be encrypted in the clear
+			continue
+		}
+		key := s.key(p, a)
+		n, err := s.client.PutFile(ctx, key, p)
+		if err != nil {
+			s.e.m.ArchivesShipped.WithLabelValues(a.Namespace, "failed").Inc()
+			s.e.log.WithError(err).WithField("file", p).Warn("archive upload failed")
+			continue
+		}
+		s.e.jrnl.Shipped(p, key)
+		s.e.m.ArchivesShipped.WithLabelValues(a.Namespace, "ok").Inc()
+		s.e.m.ShippedBytes.WithLabelValues(a.Namespace).Add(float64(n))
+	}
+}
+
+// key builds <prefix>/<namespace>/<pod>/<node>/<rotated>-<name>.
+func (s *shipper) key(p string, a ArchiveState) string {
+	var c discover.Captures
+	if r := s.e.rootFor(a.Base); r != nil {
+		c, _ = r.layout.Infer(r.path, a.Base)
+	}
+	stamp := a.Rotated.UTC().Format("20060102T150405Z")
+	return path.Join(s.e.cfg.Shipping.Prefix, a.Namespace, c.Pod, util.NodeName(), stamp+"-"+filepath.Base(p))
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package engine
+
+import (
+	"fmt"
+	"os"
+)
+
+func lookupSignal(name string) (os.Signal, error) {
+	return nil, fmt.Errorf("reopen signals are not supported on this platform")
+}
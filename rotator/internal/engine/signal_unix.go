@@ -0,0 +1,28 @@
+//go:build unix
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// reopenSignals are the signals writers may be sent. INT and TERM ask a
+// process to stop, so a config naming them would kill writers that do not
+// trap them on every rotation.
+var reopenSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// lookupSignal parses a signal name such as HUP or SIGUSR1.
+func lookupSignal(name string) (os.Signal, error) {
+	sig, ok := reopenSignals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return nil, fmt.Errorf("unknown reopen signal %q", name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+)
+
+// tierCheckInterval is how often known archives are checked against their
+// policy's tiers.
+const tierCheckInterval = time.Minute
+
+// sweepTiers queues re-compression for archives that have aged past a tier
+// they have not reached yet. Intermediate tiers are skipped when an archive
+// already qualifies for a later one. Archives of namespaces that encrypt are
+// queued for encryption instead.
+func (q *compressQueue) sweepTiers() {
+	queued := map[string]bool{}
+	for _, job := range q.e.jrnl.Queued() {
+		queued[job.Path] = true
+	}
+	pols := map[string]config.PolicyConfig{}
+	now := time.Now()
+	for path, a := range q.e.jrnl.Archives() {
+		if queued[path] {
+			continue
+		}
+		if a.KeyID != "" {
+			continue
+		}
+		if q.e.encryptionFor(a.Namespace, a.Base) != nil {
+			// compressed before encryption was enabled, or encryption failed
+			_ = q.add(CompressJob{Path: path, Base: a.Base, Namespace: a.Namespace, Encrypt: true, Due: now})
+			continue
+		}
+		key := a.Namespace + "\x00" + a.Base
+		pol, ok := pols[key]
+		if !ok {
+			pol = q.e.pol.EffectivePolicy(a.Namespace, a.Base)
+			pols[key] = pol
+		}
+		next := 0
+		for i := a.Tier; i < len(pol.Tiers); i++ {
+			if now.Sub(a.Rotated) >= pol.Tiers[i].After {
+				next = i + 1
+			}
+		}
+		if next == 0 {
+			continue
+		}
+		t := pol.Tiers[next-1]
+		_ = q.add(CompressJob{Path: path, Base: a.Base, Namespace: a.Namespace, Codec: t.Codec, Level: t.Level, Tier: next, Due: now})
+	}
+}
+
+// recompress moves an archive to the job's tier and records the result.
+func (q *compressQueue) recompress(job CompressJob) error {
+	a, ok := q.e.jrnl.Archives()[job.Path]
+	if !ok {
+		return fmt.Errorf("%s is not a known archive", job.Path)
+	}
+	from, err := codecFor(a.Codec)
+	if err != nil {
+		return err
+	}
+	to, err := codecFor(job.Codec)
+	if err != nil {
+		return err
+	}
+	dst, saved, err := recompressFile(job.Path, from, to, job.Level)
+	if err != nil {
+		q.e.m.Recompressions.WithLabelValues(job.Namespace, to.Name(), "failed").Inc()
+		return err
+	}
+	q.e.recordArchive(dst, job.Path, ManifestEntry{Codec: to.Name(), Last: a.Rotated})
+	a.Codec, a.Level, a.Tier = to.Name(), job.Level, job.Tier
+	q.e.jrnl.Archived(dst, job.Path, a)
+	q.e.m.Recompressions.WithLabelValues(job.Namespace, to.Name(), "ok").Inc()
+	q.e.m.RecompressSavedBytes.WithLabelValues(job.Namespace).Add(float64(saved))
+	return nil
+}
+
+// recompressFile re-encodes src (compressed with from) using to at level.
+// The new archive is written to a temp file and verified by decompressing it
+// and comparing checksums before it replaces src. It returns the new path
+// and how many bytes were saved (negative if it grew).
+func recompressFile(src string, from, to Codec, level int) (string, int64, error) {
+	dst := strings.TrimSuffix(src, from.Ext()) + to.Ext()
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return "", 0, err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return "", 0, err
+	}
+	defer in.Close()
+	zr, err := from.NewReader(in)
+	if err != nil {
+		return "", 0, err
+	}
+	defer zr.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	zw, err := newFileWriter(to, tmp, level, strings.TrimSuffix(filepath.Base(src), from.Ext()), srcInfo.ModTime())
+	if err != nil {
+		return "", 0, err
+	}
+	want := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(zw, want), zr); err != nil {
+		_ = zw.Close()
+		return "", 0, err
+	}
+	if err := zw.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", 0, err
+	}
+
+	// verify before the old copy goes away
+	if err := verifyEncoded(tmp, to, want.Sum(nil)); err != nil {
+		return "", 0, fmt.Errorf("verify %s: %w", dst, err)
+	}
+	tmpInfo, err := tmp.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if err := copyOwner(tmp, srcInfo); err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	// keep the original mtime so retention still sees the archive's real age
+	if err := copyTimes(tmp.Name(), srcInfo); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", 0, err
+	}
+	if dst != src {
+		if err := os.Remove(src); err != nil {
+			return "", 0, err
+		}
+	}
+	return dst, srcInfo.Size() - tmpInfo.Size(), nil
+}
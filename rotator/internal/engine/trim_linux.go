@@ -0,0 +1,53 @@
+package engine
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// collapseHead removes the whole filesystem blocks before off from the start
+// of f and returns how many bytes it removed.
+func collapseHead(f *os.File, off int64) (int64, error) {
+	var st unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &st); err != nil {
+		return 0, err
+	}
+	bs := int64(st.Bsize)
+	if bs <= 0 {
+		return 0, unix.EOPNOTSUPP
+	}
+	n := off / bs * bs
+	if n == 0 {
+		return 0, nil
+	}
+	if err := unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_COLLAPSE_RANGE, 0, n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// punchHole frees [off, off+n) of f, which then reads as zeros; the size is
+// unchanged.
+func punchHole(f *os.File, off, n int64) error {
+	return unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, off, n)
+}
+
+// dataStart returns the offset of the first data in f, past any hole at its
+// head.
+func dataStart(f *os.File) int64 {
+	off, err := f.Seek(0, unix.SEEK_DATA)
+	if err != nil {
+		return 0
+	}
+	return off
+}
+
+// unsupported reports whether err means the kernel or filesystem lacks a
+// feature, as opposed to a real failure.
+func unsupported(err error) bool {
+	return errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EXDEV) ||
+		errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.EPERM)
+}
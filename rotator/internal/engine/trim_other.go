@@ -0,0 +1,24 @@
+//go:build !linux
+
+package engine
+
+import (
+	"errors"
+	"os"
+)
+
+func collapseHead(f *os.File, off int64) (int64, error) {
+	return 0, errTrimUnsupported
+}
+
+func punchHole(f *os.File, off, n int64) error {
+	return errTrimUnsupported
+}
+
+func dataStart(f *os.File) int64 {
+	return 0
+}
+
+func unsupported(err error) bool {
+	return errors.Is(err, errTrimUnsupported)
+}
@@ -0,0 +1,133 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+)
+
+// Verify outcomes.
+const (
+	VerifyOK         = "ok"
+	VerifyMismatch   = "mismatch"   // size or checksum differs from the manifest
+	VerifyUnreadable = "unreadable" // compressed stream does not decode
+	VerifyMissing    = "missing"    // in the manifest but gone from disk
+	VerifyUnrecorded = "unrecorded" // looks like an archive but has no entry
+)
+
+// VerifyResult is the outcome of checking one archive.
+type VerifyResult struct {
+	Path   string
+	Status string
+	Err    error
+}
+
+// Failed reports whether the result means the archive cannot be trusted.
+func (r VerifyResult) Failed() bool {
+	return r.Status == VerifyMismatch || r.Status == VerifyUnreadable || r.Status == VerifyMissing
+}
+
+// Verify checks every archive under the discovery root and the configured
+// archive directories against their manifests, calling fn for each one.
+// Compressed archives are also decompressed to make sure they are readable.
+// It does not need the journal, so it can run next to a live rotator.
+func Verify(cfg *config.Config, fn func(VerifyResult)) {
+	roots := archiveRootsOf(cfg)
+	for _, root := range roots {
+		_ = filepath.WalkDir(root, func(dir string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			if dir != root && isRoot(roots, dir) {
+				return filepath.SkipDir
+			}
+			verifyDir(dir, fn)
+			return nil
+		})
+	}
+}
+
+func verifyDir(dir string, fn func(VerifyResult)) {
+	m, err := readManifest(dir)
+	if err != nil {
+		fn(VerifyResult{Path: filepath.Join(dir, manifestName), Status: VerifyUnreadable, Err: err})
+		return
+	}
+	names := make([]string, 0, len(m.Archives))
+	for n := range m.Archives {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		fn(verifyArchive(filepath.Join(dir, n), m.Archives[n]))
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, ent := range entries {
+		n := ent.Name()
+		if !ent.Type().IsRegular() || strings.HasPrefix(n, ".") {
+			continue
+		}
+		if _, ok := m.Archives[n]; ok {
+			continue
+		}
+		p := filepath.Join(dir, n)
+		c, compressed := codecByExt(p)
+		if _, numbered := archiveBase(trimCodecExt(p)); !compressed && !numbered {
+			continue
+		}
+		r := VerifyResult{Path: p, Status: VerifyUnrecorded}
+		if compressed {
+			if err := decodeAll(p, c); err != nil {
+				r.Status, r.Err = VerifyUnreadable, err
+			}
+		}
+		fn(r)
+	}
+}
+
+func verifyArchive(p string, ent ManifestEntry) VerifyResult {
+	sum, size, err := digestFile(p)
+	if os.IsNotExist(err) {
+		return VerifyResult{Path: p, Status: VerifyMissing}
+	}
+	if err != nil {
+		return VerifyResult{Path: p, Status: VerifyUnreadable, Err: err}
+	}
+	if size != ent.Size {
+		return VerifyResult{Path: p, Status: VerifyMismatch, Err: fmt.Errorf("size %d, recorded %d", size, ent.Size)}
+	}
+	if ent.SHA256 != "" && sum != ent.SHA256 {
+		return VerifyResult{Path: p, Status: VerifyMismatch, Err: fmt.Errorf("sha256 %s, recorded %s", sum, ent.SHA256)}
+	}
+	if c, ok := codecByExt(p); ok {
+		if err := decodeAll(p, c); err != nil {
+			return VerifyResult{Path: p, Status: VerifyUnreadable, Err: err}
+		}
+	}
+	return VerifyResult{Path: p, Status: VerifyOK}
+}
+
+// decodeAll reads the whole compressed stream at p and discards it.
+func decodeAll(p string, c Codec) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := c.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	_, err = io.Copy(io.Discard, zr)
+	return err
+}
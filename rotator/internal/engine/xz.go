@@ -0,0 +1,39 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/ulikunitz/xz"
+)
+
+func init() { registerCodec(xzCodec{}) }
+
+// xzCodec favours ratio over speed. Levels 1-9 pick the dictionary size of
+// the matching xz(1) preset.
+type xzCodec struct{}
+
+var xzDictCaps = [...]int{1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+func (xzCodec) Name() string { return "xz" }
+func (xzCodec) Ext() string  { return ".xz" }
+
+func (xzCodec) CheckLevel(level int) error { return levelIn(level, 1, 9) }
+
+func (xzCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	cfg := xz.WriterConfig{}
+	if level > 0 {
+		if level > 9 {
+			level = 9
+		}
+		cfg.DictCap = xzDictCaps[level-1]
+	}
+	return cfg.NewWriter(w)
+}
+
+func (xzCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	xr, err := xz.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(xr), nil
+}
@@ -0,0 +1,34 @@
+package engine
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func init() { registerCodec(zstdCodec{}) }
+
+// zstdCodec takes zstd's native 1-22 levels, mapped onto the encoder's
+// speed presets.
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+func (zstdCodec) Ext() string  { return ".zst" }
+
+func (zstdCodec) CheckLevel(level int) error { return levelIn(level, 1, 22) }
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	opts := []zstd.EOption{}
+	if level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return zstd.NewWriter(w, opts...)
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
@@ -8,14 +8,35 @@ import (
 )
 
 type Registry struct {
-	RotationsTotal      *prometheus.CounterVec
-	BytesRotatedTotal   *prometheus.CounterVec
-	ErrorsTotal         *prometheus.CounterVec
-	NamespaceUsageBytes *prometheus.GaugeVec
-	OverridesApplied    *prometheus.CounterVec
-	ScanCycles          prometheus.Counter
-	FilesDiscovered     prometheus.Gauge
-	reg                 *prometheus.Registry
+	RotationsTotal       *prometheus.CounterVec
+	BytesRotatedTotal    *prometheus.CounterVec
+	ErrorsTotal          *prometheus.CounterVec
+	NamespaceUsageBytes  *prometheus.GaugeVec
+	OverridesApplied     *prometheus.CounterVec
+	ScanCycles           prometheus.Counter
+	WatchBatches         prometheus.Counter
+	FilesDiscovered      *prometheus.GaugeVec
+	ScanDuration         prometheus.Histogram
+	ScanFilesPerSecond   prometheus.Gauge
+	ScanDirs             *prometheus.CounterVec
+	RecoveredIntents     *prometheus.CounterVec
+	JournalCorruptions   prometheus.Counter
+	FileResets           *prometheus.CounterVec
+	CompressQueueDepth   prometheus.Gauge
+	CompressLag          prometheus.Histogram
+	Recompressions       *prometheus.CounterVec
+	RecompressSavedBytes *prometheus.CounterVec
+	TruncateLostBytes    *prometheus.CounterVec
+	TruncateTailBytes    *prometheus.CounterVec
+	TruncateCopySeconds  *prometheus.HistogramVec
+	AutoModeRefusals     *prometheus.CounterVec
+	Reopens              *prometheus.CounterVec
+	HookRuns             *prometheus.CounterVec
+	NotifyEvents         *prometheus.CounterVec
+	ArchivesShipped      *prometheus.CounterVec
+	ShippedBytes         *prometheus.CounterVec
+	DeletionsDeferred    *prometheus.CounterVec
+	reg                  *prometheus.Registry
 }
 
 func NewRegistry() *Registry {
@@ -24,11 +45,11 @@ func NewRegistry() *Registry {
 		RotationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "rotator_rotations_total",
 			Help: "Total number of rotations performed",
-		}, []string{"namespace", "technique"}),
+		}, []string{"root", "namespace", "technique"}),
 		BytesRotatedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "rotator_bytes_rotated_total",
 			Help: "Total bytes rotated per namespace",
-		}, []string{"namespace"}),
+		}, []string{"root", "namespace"}),
 		ErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "rotator_errors_total",
 			Help: "Total number of errors by type",
@@ -36,7 +57,7 @@ func NewRegistry() *Registry {
 		NamespaceUsageBytes: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "rotator_ns_usage_bytes",
 			Help: "Per-namespace archived usage in bytes",
-		}, []string{"namespace"}),
+		}, []string{"root", "namespace"}),
 		OverridesApplied: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "rotator_overrides_applied_total",
 			Help: "Overrides applied count by type",
@@ -45,22 +66,108 @@ func NewRegistry() *Registry {
 			Name: "rotator_scan_cycles_total",
 			Help: "Total number of scan cycles performed",
 		}),
-		FilesDiscovered: prometheus.NewGauge(prometheus.GaugeOpts{
+		WatchBatches: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rotator_watch_batches_total",
+			Help: "Batches of written files reported by file events",
+		}),
+		FilesDiscovered: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "rotator_files_discovered",
 			Help: "Current number of log files discovered",
+		}, []string{"root"}),
+		ScanDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rotator_scan_duration_seconds",
+			Help:    "Time taken by a full discovery scan, including processing the files it reports",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 9),
 		}),
+		ScanFilesPerSecond: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rotator_scan_files_per_second",
+			Help: "Files reported per second by the last full discovery scan",
+		}),
+		ScanDirs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_scan_dirs_total",
+			Help: "Directories walked by discovery scans, by whether they were read or their cached listing reused (listed, cached)",
+		}, []string{"result"}),
+		RecoveredIntents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_recovered_intents_total",
+			Help: "Interrupted rotations replayed from the journal at startup",
+		}, []string{"technique", "outcome"}),
+		JournalCorruptions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rotator_journal_corruptions_total",
+			Help: "Times the journal snapshot or log failed validation on load",
+		}),
+		FileResets: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_file_resets_total",
+			Help: "Live files replaced or truncated by their writer rather than the rotator",
+		}, []string{"namespace", "reason"}),
+		Reopens: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_reopens_total",
+			Help: "Writers signalled to reopen after rename, by result (reopened, stale, idle, no_process, error)",
+		}, []string{"namespace", "result"}),
+		HookRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_hook_runs_total",
+			Help: "Rotation hook runs by hook (prerotate, postrotate, lastaction) and result (ok, failed, timeout)",
+		}, []string{"namespace", "hook", "result"}),
+		NotifyEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_notify_events_total",
+			Help: "Webhook events by result (delivered, failed, dropped)",
+		}, []string{"webhook", "result"}),
+		ArchivesShipped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_archives_shipped_total",
+			Help: "Archive uploads to object storage by result (ok, failed)",
+		}, []string{"namespace", "result"}),
+		ShippedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_shipped_bytes_total",
+			Help: "Bytes uploaded to object storage",
+		}, []string{"namespace"}),
+		DeletionsDeferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_deletion_deferred_total",
+			Help: "Deletions and truncations put off because a log shipper has not read the data, by action (retention, budget, copytruncate, trim)",
+		}, []string{"namespace", "action"}),
+		CompressQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "rotator_compress_queue_depth",
+			Help: "Archives waiting to be compressed",
+		}),
+		CompressLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rotator_compress_lag_seconds",
+			Help:    "Delay between a compression job becoming due and a worker starting it",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+		}),
+		Recompressions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_recompressions_total",
+			Help: "Archives re-compressed into a later tier, by target codec and result",
+		}, []string{"namespace", "codec", "result"}),
+		RecompressSavedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_recompress_saved_bytes_total",
+			Help: "Bytes saved by tiered re-compression",
+		}, []string{"namespace"}),
+		TruncateLostBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_copytruncate_lost_bytes_total",
+			Help: "Bytes written between the last copy read and the truncate, lost by copytruncate",
+		}, []string{"namespace"}),
+		TruncateTailBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_copytruncate_tail_bytes_total",
+			Help: "Bytes of unfinished lines kept in the live file by line-boundary copytruncate",
+		}, []string{"namespace"}),
+		TruncateCopySeconds: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rotator_copytruncate_copy_seconds",
+			Help:    "Time spent copying for copytruncate, by strategy (reflink, copy_file_range, userspace)",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"strategy"}),
+		AutoModeRefusals: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rotator_auto_mode_refusals_total",
+			Help: "Rotations skipped by auto mode because no technique is safe for how the file is held",
+		}, []string{"namespace", "reason"}),
 		reg: r,
 	}
-	r.MustRegister(m.RotationsTotal, m.BytesRotatedTotal, m.ErrorsTotal, m.NamespaceUsageBytes, m.OverridesApplied, m.ScanCycles, m.FilesDiscovered)
+	r.MustRegister(m.RotationsTotal, m.BytesRotatedTotal, m.ErrorsTotal, m.NamespaceUsageBytes, m.OverridesApplied, m.ScanCycles, m.WatchBatches, m.FilesDiscovered, m.ScanDuration, m.ScanFilesPerSecond, m.ScanDirs, m.RecoveredIntents, m.JournalCorruptions, m.FileResets, m.CompressQueueDepth, m.CompressLag, m.Recompressions, m.RecompressSavedBytes, m.TruncateLostBytes, m.TruncateTailBytes, m.TruncateCopySeconds, m.AutoModeRefusals, m.Reopens, m.HookRuns, m.NotifyEvents, m.ArchivesShipped, m.ShippedBytes, m.DeletionsDeferred)
 
 	// Initialize all metrics so they appear in /metrics endpoint even with zero values
-	m.FilesDiscovered.Set(0)
-	m.RotationsTotal.WithLabelValues("_default", "rename").Add(0) // Initialize with dummy labels
-	m.BytesRotatedTotal.WithLabelValues("_default").Add(0)        // Will show up as zero
-	m.NamespaceUsageBytes.WithLabelValues("_default").Set(0)      // Will show up as zero
-	m.OverridesApplied.WithLabelValues("namespace").Add(0)        // Will show up as zero
-	m.OverridesApplied.WithLabelValues("path").Add(0)             // Will show up as zero
-	m.ErrorsTotal.WithLabelValues("discovery").Add(0)             // Will show up as zero
+	m.RotationsTotal.WithLabelValues("default", "_default", "rename").Add(0) // Initialize with dummy labels
+	m.BytesRotatedTotal.WithLabelValues("default", "_default").Add(0)        // Will show up as zero
+	m.NamespaceUsageBytes.WithLabelValues("default", "_default").Set(0)      // Will show up as zero
+	m.OverridesApplied.WithLabelValues("namespace").Add(0)                   // Will show up as zero
+	m.OverridesApplied.WithLabelValues("path").Add(0)                        // Will show up as zero
+	m.ErrorsTotal.WithLabelValues("discovery").Add(0)                        // Will show up as zero
 
 	return m
 }
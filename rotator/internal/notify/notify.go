@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// Event kinds.
+const (
+	Rotated          = "rotated"
+	Compressed       = "compressed"
+	RetentionDeleted = "retention_deleted"
+	BudgetPurged     = "budget_purged"
+	Error            = "error"
+)
+
+const (
+	maxBackoff     = time.Minute
+	initialBackoff = time.Second
+)
+
+// Event is one thing that happened to a log file, as POSTed to webhooks.
+type Event struct {
+	Kind      string    `json:"kind"`
+	Time      time.Time `json:"time"`
+	Node      string    `json:"node"`
+	Namespace string    `json:"namespace,omitempty"`
+	Pod       string    `json:"pod,omitempty"`
+	File      string    `json:"file,omitempty"`
+	Archive   string    `json:"archive,omitempty"`
+	Bytes     int64     `json:"bytes,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Notifier delivers events to webhooks in the background. Each webhook has
+// its own bounded queue, so a slow receiver delays only itself and events
+// beyond the queue are dropped rather than blocking the caller.
+type Notifier struct {
+	cfg    config.NotifyConfig
+	m      *metrics.Registry
+	log    *log.Entry
+	client *http.Client
+	hooks  []*webhook
+	wg     sync.WaitGroup
+}
+
+type webhook struct {
+	cfg    config.WebhookConfig
+	name   string
+	events map[string]bool // nil means all
+	q      chan Event
+}
+
+// New builds a notifier for cfg. It does nothing when no webhooks are set.
+func New(cfg config.NotifyConfig, m *metrics.Registry, logger *log.Entry) *Notifier {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = 5
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	n := &Notifier{cfg: cfg, m: m, log: logger, client: &http.Client{Timeout: cfg.Timeout}}
+	for _, wc := range cfg.Webhooks {
+		h := &webhook{cfg: wc, name: wc.Name, q: make(chan Event, cfg.QueueSize)}
+		if h.name == "" {
+			if u, err := url.Parse(wc.URL); err == nil {
+				h.name = u.Host
+			}
+		}
+		if len(wc.Events) > 0 {
+			h.events = map[string]bool{}
+			for _, k := range wc.Events {
+				h.events[k] = true
+			}
+		}
+		n.hooks = append(n.hooks, h)
+	}
+	return n
+}
+
+// Start delivers events until ctx is done, then flushes what is queued once.
+func (n *Notifier) Start(ctx context.Context) {
+	for _, h := range n.hooks {
+		n.wg.Add(1)
+		go n.run(ctx, h)
+	}
+}
+
+// Wait blocks until delivery has stopped.
+func (n *Notifier) Wait() { n.wg.Wait() }
+
+// Send queues ev for every webhook subscribed to its kind, never blocking.
+func (n *Notifier) Send(ev Event) {
+	if len(n.hooks) == 0 {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if ev.Node == "" {
+		ev.Node = util.NodeName()
+	}
+	for _, h := range n.hooks {
+		if h.events != nil && !h.events[ev.Kind] {
+			continue
+		}
+		select {
+		case h.q <- ev:
+		default:
+			n.m.NotifyEvents.WithLabelValues(h.name, "dropped").Inc()
+		}
+	}
+}
+
+func (n *Notifier) run(ctx context.Context, h *webhook) {
+	defer n.wg.Done()
+	tick := time.NewTicker(n.cfg.FlushInterval)
+	defer tick.Stop()
+	var batch []Event
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			n.deliver(ctx, h, batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			// best effort for what is left, without retrying
+			for len(h.q) > 0 {
+				batch = append(batch, <-h.q)
+			}
+			if len(batch) > 0 {
+				n.post(context.Background(), h, batch)
+			}
+			return
+		case ev := <-h.q:
+			batch = append(batch, ev)
+			if len(batch) >= n.cfg.BatchSize {
+				flush(ctx)
+			}
+		case <-tick.C:
+			flush(ctx)
+		}
+	}
+}
+
+// deliver posts a batch, retrying with exponential backoff.
+func (n *Notifier) deliver(ctx context.Context, h *webhook, batch []Event) {
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		err := n.post(ctx, h, batch)
+		if err == nil {
+			return
+		}
+		if attempt >= n.cfg.MaxRetries {
+			n.m.NotifyEvents.WithLabelValues(h.name, "failed").Add(float64(len(batch)))
+			n.log.WithError(err).WithField("webhook", h.name).WithField("events", len(batch)).Warn("giving up on webhook delivery")
+			return
+		}
+		select {
+		case <-ctx.Done():
+			n.m.NotifyEvents.WithLabelValues(h.name, "failed").Add(float64(len(batch)))
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, h *webhook, batch []Event) error {
+	body, err := json.Marshal(struct {
+		Events []Event `json:"events"`
+	}{batch})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook %s: %s", h.name, resp.Status)
+	}
+	n.m.NotifyEvents.WithLabelValues(h.name, "delivered").Add(float64(len(batch)))
+	return nil
+}
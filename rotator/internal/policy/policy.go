@@ -10,20 +10,28 @@ import (
 )
 
 type Engine struct {
-	cfg *config.Config
-	m   *metrics.Registry
+	cfg   *config.Config
+	m     *metrics.Registry
+	roots []config.RootConfig
 }
 
 func New(cfg *config.Config, m *metrics.Registry) *Engine {
-	return &Engine{cfg: cfg, m: m}
+	return &Engine{cfg: cfg, m: m, roots: cfg.DiscoveryRoots()}
 }
 
-// EffectivePolicy merges defaults -> namespace override -> path override
+// EffectivePolicy merges defaults -> root -> namespace override -> path override.
+// Callers pass the file's group, which is its namespace outside host mode.
 func (e *Engine) EffectivePolicy(namespace, fullPath string) config.PolicyConfig {
 	eff := e.cfg.Defaults.Policy
 
-	// namespace-level
-	if ns, ok := e.cfg.Overrides.Namespaces[namespace]; ok {
+	// root-level
+	r := config.RootOf(e.roots, fullPath)
+	if r != nil && r.Policy != nil {
+		mergePolicy(&eff, r.Policy)
+	}
+
+	// namespace-level; host roots are keyed by path overrides alone
+	if ns, ok := e.cfg.Overrides.Namespaces[namespace]; ok && (r == nil || r.Discovery.Mode != config.ModeHost) {
 		if ns.Policy != nil {
 			mergePolicy(&eff, ns.Policy)
 			e.m.OverridesApplied.WithLabelValues("namespace").Inc()
@@ -67,6 +75,39 @@ func mergePolicy(base *config.PolicyConfig, o *config.PolicyConfig) {
 	if strings.TrimSpace(o.DefaultMode) != "" {
 		base.DefaultMode = o.DefaultMode
 	}
+	if o.LineBoundary != nil {
+		base.LineBoundary = o.LineBoundary
+	}
+	if o.TrimKeep != 0 {
+		base.TrimKeep = o.TrimKeep
+	}
+	if strings.TrimSpace(o.TrimMethod) != "" {
+		base.TrimMethod = o.TrimMethod
+	}
+	if o.TrimArchive != nil {
+		base.TrimArchive = o.TrimArchive
+	}
+	if o.Reopen != nil {
+		base.Reopen = o.Reopen
+	}
+	if o.Hooks != nil {
+		base.Hooks = o.Hooks
+	}
+	if strings.TrimSpace(o.Codec) != "" {
+		base.Codec = o.Codec
+	}
+	if o.CompressLevel != 0 {
+		base.CompressLevel = o.CompressLevel
+	}
+	if len(o.Tiers) > 0 {
+		base.Tiers = o.Tiers
+	}
+	if strings.TrimSpace(o.ArchiveName) != "" {
+		base.ArchiveName = o.ArchiveName
+	}
+	if strings.TrimSpace(o.ArchiveDir) != "" {
+		base.ArchiveDir = o.ArchiveDir
+	}
 }
 
 func matchGlobs(pattern, path string) bool {
@@ -0,0 +1,92 @@
+package procfs
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// Root is where the host's /proc is visible. Seeing other pods' processes
+// needs hostPID.
+const Root = "/proc"
+
+// open(2) flags as reported in fdinfo, in octal.
+const (
+	oAccMode = 0o3 // O_RDONLY is 0
+	oAppend  = 0o2000
+)
+
+// Holder is one open file descriptor of a process.
+type Holder struct {
+	PID    int
+	FD     int
+	Write  bool // opened for writing
+	Append bool // writes go to the end (O_APPEND)
+}
+
+// Holders returns every descriptor under root that refers to the file with
+// the given device and inode, skipping the calling process. Processes that
+// exit or deny access while being scanned are skipped.
+func Holders(root string, dev, ino uint64) ([]Holder, error) {
+	procs, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	self := os.Getpid()
+	var out []Holder
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fdDir := filepath.Join(root, p.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			fi, err := os.Stat(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			if d, i := util.FileID(fi); d != dev || i != ino {
+				continue
+			}
+			n, _ := strconv.Atoi(fd.Name())
+			flags, err := fdFlags(filepath.Join(root, p.Name(), "fdinfo", fd.Name()))
+			if err != nil {
+				continue
+			}
+			out = append(out, Holder{
+				PID:    pid,
+				FD:     n,
+				Write:  flags&oAccMode != 0,
+				Append: flags&oAppend != 0,
+			})
+		}
+	}
+	return out, nil
+}
+
+// fdFlags reads the "flags:" line of an fdinfo file.
+func fdFlags(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "flags:"); ok {
+			return strconv.ParseInt(strings.TrimSpace(v), 8, 64)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, err
+	}
+	return 0, os.ErrNotExist
+}
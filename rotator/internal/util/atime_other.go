@@ -0,0 +1,14 @@
+//go:build !linux
+
+package util
+
+import (
+	"os"
+	"time"
+)
+
+// AccessTime is not tracked on this platform; it returns the modification
+// time of fi.
+func AccessTime(fi os.FileInfo) time.Time {
+	return fi.ModTime()
+}
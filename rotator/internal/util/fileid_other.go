@@ -0,0 +1,15 @@
+//go:build !unix
+
+package util
+
+import "os"
+
+// FileID is not supported on this platform.
+func FileID(fi os.FileInfo) (dev, ino uint64) {
+	return 0, 0
+}
+
+// FileOwner is not supported on this platform.
+func FileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,13 @@
+package util
+
+import "os"
+
+// NodeName returns the Kubernetes node name from NODE_NAME (set through the
+// downward API), falling back to the hostname.
+func NodeName() string {
+	if n := os.Getenv("NODE_NAME"); n != "" {
+		return n
+	}
+	h, _ := os.Hostname()
+	return h
+}
@@ -0,0 +1,129 @@
+package test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/checkpoint"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func TestCheckpointFormats(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"pos":         "/var/log/a.log\t0000000000000004\t000000000000000a\n/var/log/old.log\tffffffffffffffff\t000000000000000b\n",
+		"vector.json": `{"version":"1","checkpoints":[{"fingerprint":{"dev_inode":[7,12]},"position":3},{"fingerprint":{"first_lines_checksum":99},"position":1}]}`,
+		"flb.json":    `[{"name":"/var/log/c.log","offset":2,"inode":13}]`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := checkpoint.New([]config.CheckpointSource{
+		{Format: checkpoint.Fluentd, Path: filepath.Join(dir, "pos")},
+		{Format: checkpoint.Vector, Path: filepath.Join(dir, "vector.json")},
+		{Format: checkpoint.FluentBit, Path: filepath.Join(dir, "flb.json")},
+		{Format: checkpoint.Fluentd, Path: filepath.Join(dir, "missing-*.pos")},
+	})
+	set, err := r.Positions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		dev, ino uint64
+		want     int64
+	}{
+		{0, 10, 6}, // fluentd read 4 of 10
+		{0, 11, 0}, // unwatched
+		{7, 12, 7}, // vector read 3 of 10
+		{8, 12, 0}, // same inode on another device
+		{0, 13, 8}, // fluent bit read 2 of 10
+		{0, 99, 0}, // untracked
+	} {
+		if got := set.Unread(c.dev, c.ino, 10); got != c.want {
+			t.Errorf("dev %d ino %d: unread %d, want %d", c.dev, c.ino, got, c.want)
+		}
+	}
+}
+
+func TestRetentionWaitsForShipper(t *testing.T) {
+	dir := t.TempDir()
+	pos := filepath.Join(dir, ".fluentd.pos")
+	cfg := &config.Config{
+		Defaults:    config.Defaults{Discovery: config.DiscoveryConfig{Path: dir}},
+		State:       config.StateConfig{Path: filepath.Join(dir, ".state", "state.json")},
+		Checkpoints: config.CheckpointConfig{Sources: []config.CheckpointSource{{Format: checkpoint.Fluentd, Path: pos}}},
+	}
+	m := metrics.NewRegistry()
+	rot, err := engine.New(cfg, m, util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rot.Close()
+
+	live := filepath.Join(dir, "app.log")
+	pol := config.PolicyConfig{Size: 1, KeepFiles: 1}
+	writeAndRotate(t, rot, live, "first\n", pol)
+	// the shipper is still at byte 2 of the first archive
+	fi, _ := os.Stat(live + ".1")
+	_, ino := util.FileID(fi)
+	if err := os.WriteFile(pos, []byte(fmt.Sprintf("%s\t%016x\t%016x\n", live, 2, ino)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeAndRotate(t, rot, live, "second\n", pol)
+
+	if !util.FileExists(live + ".1") {
+		t.Fatal("archive the shipper has not finished was deleted")
+	}
+	if got := testutil.ToFloat64(m.DeletionsDeferred.WithLabelValues("ns", "retention")); got != 1 {
+		t.Fatalf("expected one deferred deletion, got %v", got)
+	}
+}
+
+func TestDeferredTruncateLeavesShiftedArchivesAlone(t *testing.T) {
+	dir := t.TempDir()
+	pos := filepath.Join(dir, ".fluentd.pos")
+	cfg := &config.Config{
+		Defaults:    config.Defaults{Discovery: config.DiscoveryConfig{Path: dir}},
+		State:       config.StateConfig{Path: filepath.Join(dir, ".state", "state.json")},
+		Checkpoints: config.CheckpointConfig{Sources: []config.CheckpointSource{{Format: checkpoint.Fluentd, Path: pos}}},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rot.Close()
+
+	live := filepath.Join(dir, "app.log")
+	archives := map[string]string{live + ".1": "second\n", live + ".2": "first\n"}
+	for p, data := range archives {
+		_ = os.WriteFile(p, []byte(data), 0o644)
+	}
+	_ = os.WriteFile(live, []byte("unread\n"), 0o644)
+	// the shipper has not read the live file at all
+	fi, _ := os.Stat(live)
+	_, ino := util.FileID(fi)
+	if err := os.WriteFile(pos, []byte(fmt.Sprintf("%s\t%016x\t%016x\n", live, 0, ino)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pol := config.PolicyConfig{Size: 1, DefaultMode: "copytruncate", ArchiveName: "shift"}
+	for i := 0; i < 3; i++ {
+		writeAndRotate(t, rot, live, "unread\n", pol)
+	}
+	for p, want := range archives {
+		if got, err := os.ReadFile(p); err != nil || string(got) != want {
+			t.Fatalf("%s: got %q (%v), want %q", filepath.Base(p), got, err, want)
+		}
+	}
+	if util.FileExists(live + ".3") {
+		t.Fatal("deferred rotations must not shift archives")
+	}
+}
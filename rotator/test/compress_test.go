@@ -0,0 +1,214 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func TestLeftoverArchivesAreCompressedOnStart(t *testing.T) {
+	for codec, ext := range map[string]string{"gzip": ".gz", "zstd": ".zst", "xz": ".xz", "lz4": ".lz4"} {
+		t.Run(codec, func(t *testing.T) {
+			dir := t.TempDir()
+			podDir := filepath.Join(dir, "logs", "ns", "pod")
+			_ = os.MkdirAll(podDir, 0o755)
+			archive := filepath.Join(podDir, "app.log.1")
+			if err := os.WriteFile(archive, []byte("rotated before a restart\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			old := time.Now().Add(-2 * time.Hour)
+			_ = os.Chtimes(archive, old, old)
+
+			cfg := &config.Config{
+				Defaults: config.Defaults{
+					Discovery: config.DiscoveryConfig{Path: filepath.Join(dir, "logs")},
+					Policy:    config.PolicyConfig{CompressAfter: time.Hour, Codec: codec, CompressLevel: 3},
+				},
+				State:       config.StateConfig{Path: filepath.Join(dir, "state", "state.json")},
+				Compression: config.CompressionConfig{Workers: 1, QueueSize: 1},
+			}
+			m := metrics.NewRegistry()
+			rot, err := engine.New(cfg, m, util.NewLogger())
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			rot.Start(ctx)
+			deadline := time.Now().Add(5 * time.Second)
+			for !util.FileExists(archive+ext) && time.Now().Before(deadline) {
+				time.Sleep(50 * time.Millisecond)
+			}
+			cancel()
+			_ = rot.Close()
+			if !util.FileExists(archive + ext) {
+				t.Fatalf("expected leftover archive to be compressed to %s", ext)
+			}
+			if util.FileExists(archive) {
+				t.Fatalf("expected source archive to be removed")
+			}
+			if got := testutil.ToFloat64(m.CompressQueueDepth); got != 0 {
+				t.Fatalf("expected empty queue, got %v", got)
+			}
+		})
+	}
+}
+
+func TestUnknownCodecRejected(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{Policy: config.PolicyConfig{Codec: "brotli"}},
+		State:    config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+	}
+	if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+		t.Fatalf("expected unknown codec to be rejected")
+	}
+}
+
+func TestOutOfRangeLevelRejected(t *testing.T) {
+	cases := map[string]config.PolicyConfig{
+		"gzip":        {CompressLevel: 19},
+		"zstd":        {Codec: "zstd", CompressLevel: 23},
+		"negative xz": {Codec: "xz", CompressLevel: -1},
+		"tier":        {Tiers: []config.ArchiveTier{{After: time.Hour, Codec: "lz4", Level: 10}}},
+	}
+	for name, p := range cases {
+		cfg := &config.Config{
+			Defaults: config.Defaults{Policy: p},
+			State:    config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		}
+		if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+			t.Fatalf("%s: expected an out-of-range level to be rejected", name)
+		}
+	}
+}
+
+func TestArchivesMoveToLaterTier(t *testing.T) {
+	dir := t.TempDir()
+	podDir := filepath.Join(dir, "logs", "ns", "pod")
+	_ = os.MkdirAll(podDir, 0o755)
+	archive := filepath.Join(podDir, "app.log.1.gz")
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write([]byte("old enough for the cold tier\n"))
+	_ = zw.Close()
+	if err := os.WriteFile(archive, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	_ = os.Chtimes(archive, old, old)
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{Path: filepath.Join(dir, "logs")},
+			Policy: config.PolicyConfig{Tiers: []config.ArchiveTier{
+				{After: time.Hour, Codec: "lz4"},
+				{After: 24 * time.Hour, Codec: "zstd", Level: 19},
+			}},
+		},
+		State:       config.StateConfig{Path: filepath.Join(dir, "state", "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 1},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rot.Start(ctx)
+	want := filepath.Join(podDir, "app.log.1.zst")
+	deadline := time.Now().Add(5 * time.Second)
+	for !util.FileExists(want) && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	if util.FileExists(archive) || util.FileExists(filepath.Join(podDir, "app.log.1.lz4")) {
+		t.Fatalf("expected archive to skip straight to the last due tier")
+	}
+	f, err := os.Open(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := zstd.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	got, err := io.ReadAll(zr)
+	if err != nil || string(got) != "old enough for the cold tier\n" {
+		t.Fatalf("unexpected contents %q (%v)", got, err)
+	}
+	if fi, _ := f.Stat(); !fi.ModTime().Equal(old) {
+		t.Fatalf("expected mtime to be preserved, got %v", fi.ModTime())
+	}
+}
+
+func TestCompressionKeepsArchiveMetadata(t *testing.T) {
+	dir := t.TempDir()
+	podDir := filepath.Join(dir, "logs", "ns", "pod")
+	_ = os.MkdirAll(podDir, 0o755)
+	archive := filepath.Join(podDir, "app.log.1")
+	if err := os.WriteFile(archive, []byte("rotated yesterday\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	_ = os.Chmod(archive, 0o640)
+	chowned := os.Chown(archive, 1234, 5678) == nil
+	old := time.Now().Add(-26 * time.Hour).Truncate(time.Second)
+	_ = os.Chtimes(archive, old, old)
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{Path: filepath.Join(dir, "logs")},
+			Policy:    config.PolicyConfig{CompressAfter: time.Hour},
+		},
+		State:       config.StateConfig{Path: filepath.Join(dir, "state", "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 1},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rot.Start(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for util.FileExists(archive) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+
+	fi, err := os.Stat(archive + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o640 || !fi.ModTime().Equal(old) {
+		t.Fatalf("got mode %v mtime %v, want 0640 and %v", fi.Mode().Perm(), fi.ModTime(), old)
+	}
+	if uid, gid, ok := util.FileOwner(fi); chowned && ok && (uid != 1234 || gid != 5678) {
+		t.Fatalf("got owner %d:%d, want 1234:5678", uid, gid)
+	}
+	f, _ := os.Open(archive + ".gz")
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zr.Name != "app.log.1" || !zr.ModTime.Equal(old) {
+		t.Fatalf("gzip header has name %q mtime %v", zr.Name, zr.ModTime)
+	}
+	if left, _ := filepath.Glob(filepath.Join(podDir, ".*.tmp-*")); len(left) != 0 {
+		t.Fatalf("temp files left behind: %v", left)
+	}
+}
@@ -31,6 +31,9 @@ func TestDefaultsApplied(t *testing.T) {
 	if loaded.Defaults.Policy.Size == 0 {
 		t.Fatalf("expected policy size default")
 	}
+	if loaded.Defaults.Policy.TrimKeep == 0 {
+		t.Fatalf("expected trimKeep default")
+	}
 }
 
 func TestByteSizeParse(t *testing.T) {
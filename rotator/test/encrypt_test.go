@@ -0,0 +1,148 @@
+package test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/crypt"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func writeKey(t *testing.T, dir, id string) []byte {
+	t.Helper()
+	key := make([]byte, crypt.KeySize)
+	_, _ = rand.Read(key)
+	if err := os.WriteFile(filepath.Join(dir, id), []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptedStreamRoundTripAndTamper(t *testing.T) {
+	keys := t.TempDir()
+	key := writeKey(t, keys, "k1")
+	for _, size := range []int{0, 100, 64 << 10, 128 << 10, 200_000} {
+		plain := make([]byte, size)
+		_, _ = rand.Read(plain)
+		var buf bytes.Buffer
+		w, err := crypt.NewWriter(&buf, "k1", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write(plain)
+		_ = w.Close()
+		sealed := buf.Bytes()
+
+		r, err := crypt.NewReader(bytes.NewReader(sealed), crypt.DirKeys(keys))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: round trip failed: %v", size, err)
+		}
+		if size < 128<<10 {
+			continue
+		}
+		// drop the last chunk: the one before it was not sealed as last
+		r, _ = crypt.NewReader(bytes.NewReader(sealed[:len(sealed)-(size-128<<10)-16]), crypt.DirKeys(keys))
+		if _, err := io.ReadAll(r); !errors.Is(err, crypt.ErrCorrupt) {
+			t.Fatalf("size %d: expected truncation to be detected, got %v", size, err)
+		}
+	}
+	var buf bytes.Buffer
+	w, _ := crypt.NewWriter(&buf, "gone", key)
+	_ = w.Close()
+	if _, err := crypt.NewReader(&buf, crypt.DirKeys(keys)); err == nil {
+		t.Fatalf("expected an unknown key ID to fail")
+	}
+}
+
+func TestNamespaceArchivesAreEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	keys := t.TempDir()
+	writeKey(t, keys, "2024-05")
+	podDir := filepath.Join(dir, "ns", "pod")
+	_ = os.MkdirAll(podDir, 0o755)
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{Path: dir},
+			Policy:    config.PolicyConfig{CompressAfter: time.Hour},
+			Budgets:   config.BudgetConfig{PerNamespaceBytes: 1 << 30},
+		},
+		Overrides: config.Overrides{Namespaces: map[string]config.NamespaceOverride{
+			"ns": {Encryption: &config.EncryptionConfig{KeyFile: filepath.Join(keys, "2024-05")}},
+		}},
+		State:       config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 4},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rot.Start(ctx)
+
+	live := filepath.Join(podDir, "app.log")
+	writeAndRotate(t, rot, live, "card=4111\n", config.PolicyConfig{Size: 1, CompressAfter: time.Millisecond})
+	enc := filepath.Join(podDir, "app.log.1.gz.enc")
+	deadline := time.Now().Add(5 * time.Second)
+	for !util.FileExists(enc) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	if util.FileExists(filepath.Join(podDir, "app.log.1.gz")) || !util.FileExists(enc) {
+		t.Fatalf("expected only the encrypted archive to remain")
+	}
+	f, _ := os.Open(enc)
+	defer f.Close()
+	r, err := crypt.NewReader(f, crypt.DirKeys(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(zr)
+	if string(got) != "card=4111\n" {
+		t.Fatalf("decrypted %q", got)
+	}
+	if s := verifyStatuses(cfg)["app.log.1.gz.enc"]; s != engine.VerifyOK {
+		t.Fatalf("expected the encrypted archive to verify, got %q", s)
+	}
+	if _, err := os.Stat(filepath.Join(podDir, "app.log.2.gz.enc")); err == nil {
+		t.Fatalf("unexpected second archive")
+	}
+}
+
+func TestEncryptionWithoutCompressionRejected(t *testing.T) {
+	keys := t.TempDir()
+	writeKey(t, keys, "k1")
+	for _, after := range []time.Duration{0, -1} {
+		cfg := &config.Config{
+			Defaults: config.Defaults{
+				Policy:     config.PolicyConfig{CompressAfter: after},
+				Encryption: config.EncryptionConfig{KeyFile: filepath.Join(keys, "k1")},
+			},
+			State: config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		}
+		if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+			t.Fatalf("compressAfter %s: expected encryption without compression to be rejected", after)
+		}
+	}
+}
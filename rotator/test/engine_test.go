@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func TestRecoverInterruptedRotations(t *testing.T) {
+	dir := t.TempDir()
+	renamed := filepath.Join(dir, "app.log")
+	copied := filepath.Join(dir, "other.log")
+	// rename finished but the live file was never recreated
+	if err := os.WriteFile(renamed+".1", []byte("old\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	// copytruncate died mid-copy
+	if err := os.WriteFile(copied, []byte("line1\nline2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(copied+".1", []byte("li"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	state := map[string]interface{}{
+		"version": 1,
+		"files":   map[string]string{},
+		"intents": map[string]engine.Intent{
+			"1": {ID: "1", Op: "rename", Path: renamed, Target: renamed + ".1", Mode: 0o640, Phase: "begun"},
+			"2": {ID: "2", Op: "copytruncate", Path: copied, Target: copied + ".1", Mode: 0o644, Phase: "begun"},
+		},
+	}
+	b, _ := json.Marshal(state)
+	statePath := filepath.Join(dir, "state", "state.json")
+	_ = os.MkdirAll(filepath.Dir(statePath), 0o755)
+	if err := os.WriteFile(statePath, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{State: config.StateConfig{Path: statePath}}
+	if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err != nil {
+		t.Fatal(err)
+	}
+	if !util.FileExists(renamed) {
+		t.Fatalf("expected live file to be recreated")
+	}
+	if util.FileExists(copied + ".1") {
+		t.Fatalf("expected partial copy to be removed")
+	}
+	if got, _ := os.ReadFile(copied); string(got) != "line1\nline2\n" {
+		t.Fatalf("live file changed: %q", got)
+	}
+}
+
+func TestJournalCorruptionReported(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "state.json")
+	// a damaged record followed by a valid-looking one is not a torn tail
+	log := "00000000 {\"k\":\"file\",\"p\":\"/x\",\"a\":\"rotated\"}\nnot-a-record\n"
+	if err := os.WriteFile(statePath+".log", []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := metrics.NewRegistry()
+	cfg := &config.Config{State: config.StateConfig{Path: statePath}}
+	rot, err := engine.New(cfg, m, util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rot.Close()
+	if got := testutil.ToFloat64(m.JournalCorruptions); got != 1 {
+		t.Fatalf("expected corruption to be counted, got %v", got)
+	}
+	matches, _ := filepath.Glob(statePath + ".log.corrupt-*")
+	if len(matches) != 1 {
+		t.Fatalf("expected damaged log to be set aside, got %v", matches)
+	}
+}
+
+func TestAgeUsesFirstSeenNotMtime(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(live, []byte("hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	_ = os.Chtimes(live, old, old)
+
+	cfg := &config.Config{State: config.StateConfig{Path: filepath.Join(dir, "state", "state.json")}}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rot.Close()
+	fi, _ := os.Stat(live)
+	dev, ino := util.FileID(fi)
+	f := discover.FileInfo{Path: live, Namespace: "ns", Pod: "pod", Size: fi.Size(), ModTimeMs: fi.ModTime().UnixMilli(), Dev: dev, Inode: ino}
+	pol := config.PolicyConfig{Age: 24 * time.Hour}
+	if err := rot.ProcessFile(context.Background(), f, pol); err != nil {
+		t.Fatal(err)
+	}
+	if util.FileExists(live + ".1") {
+		t.Fatalf("a stale mtime alone must not trigger the age policy")
+	}
+}
+
+func TestFirstSeenFilesAreSavedWithTheSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "state", "state.json")
+	cfg := &config.Config{State: config.StateConfig{Path: state}}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for i := 0; i < 50; i++ {
+		live := filepath.Join(dir, fmt.Sprintf("app%d.log", i))
+		_ = os.WriteFile(live, []byte("x\n"), 0o644)
+		fi, _ := os.Stat(live)
+		dev, ino := util.FileID(fi)
+		f := discover.FileInfo{Path: live, Namespace: "ns", Pod: "pod", Size: fi.Size(), ModTimeMs: fi.ModTime().UnixMilli(), Dev: dev, Inode: ino}
+		if err := rot.ProcessFile(context.Background(), f, config.PolicyConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, live)
+	}
+	// discovering files must not log and fsync one record each
+	if fi, err := os.Stat(state + ".log"); err == nil && fi.Size() > 0 {
+		t.Fatalf("expected no log records for newly seen files, got %d bytes", fi.Size())
+	}
+	if err := rot.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st struct {
+		Files map[string]json.RawMessage `json:"files"`
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range files {
+		if _, ok := st.Files[p]; !ok {
+			t.Fatalf("%s missing from the snapshot", p)
+		}
+	}
+}
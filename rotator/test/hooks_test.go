@@ -0,0 +1,79 @@
+package test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func TestPrerotateVetoesRotation(t *testing.T) {
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("needs false")
+	}
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	pol := config.PolicyConfig{Size: 1, Hooks: &config.HooksConfig{PreRotate: []string{"false"}}}
+	writeAndRotate(t, rot, live, "data\n", pol)
+	if util.FileExists(live + ".1") {
+		t.Fatalf("failing prerotate should have vetoed the rotation")
+	}
+}
+
+func TestVetoedRotationLeavesShiftedArchivesAlone(t *testing.T) {
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("needs false")
+	}
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	pol := config.PolicyConfig{Size: 1, ArchiveName: "shift"}
+	writeAndRotate(t, rot, live, "first\n", pol)
+	writeAndRotate(t, rot, live, "second\n", pol)
+	pol.Hooks = &config.HooksConfig{PreRotate: []string{"false"}}
+	for i := 0; i < 3; i++ {
+		writeAndRotate(t, rot, live, "vetoed\n", pol)
+	}
+	for name, want := range map[string]string{"app.log": "vetoed\n", "app.log.1": "second\n", "app.log.2": "first\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != want {
+			t.Fatalf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+	if util.FileExists(live + ".3") {
+		t.Fatal("vetoed rotations must not shift archives")
+	}
+}
+
+func TestPostrotateAndLastActionSeeRotation(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	post := filepath.Join(dir, "post.out")
+	last := filepath.Join(dir, "last.out")
+	pol := config.PolicyConfig{Size: 1, Hooks: &config.HooksConfig{
+		PostRotate: []string{"sh", "-c", `echo "$ROTATOR_NAMESPACE $ROTATOR_POD $ROTATOR_ARCHIVE" >> "$0"`, post},
+		LastAction: []string{"sh", "-c", `echo "$ROTATOR_FILES" >> "$0"`, last},
+	}}
+	writeAndRotate(t, rot, live, "data\n", pol)
+	rot.EndCycle(context.Background())
+	rot.EndCycle(context.Background()) // nothing rotated since: must not run again
+
+	got, _ := os.ReadFile(post)
+	if want := "ns pod " + live + ".1\n"; string(got) != want {
+		t.Fatalf("postrotate env: got %q, want %q", got, want)
+	}
+	got, _ = os.ReadFile(last)
+	if strings.Count(string(got), live) != 1 {
+		t.Fatalf("lastaction should run once with the rotated file, got %q", got)
+	}
+}
@@ -0,0 +1,176 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	pol "github.com/tapasyadubey/log-rotate-util/rotator/internal/policy"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func hostConfig(t *testing.T, root string) *config.Config {
+	t.Helper()
+	return &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{Path: root, Mode: config.ModeHost, MaxDepth: 4, Include: []string{"**"}, Exclude: []string{"**/*.[0-9]", "**/*.gz"}},
+			Policy:    config.PolicyConfig{Size: 100 * config.MiB},
+			Budgets:   config.BudgetConfig{PerNamespaceBytes: 1 << 30},
+		},
+		State:       config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 4},
+	}
+}
+
+func TestHostModeGroupsFilesByPath(t *testing.T) {
+	cases := []struct {
+		name   string
+		layout string
+		files  map[string]discover.FileInfo // relative path -> expected group
+		skip   []string
+	}{
+		{
+			name: "default",
+			files: map[string]discover.FileInfo{
+				"syslog":           {Group: "syslog"},
+				"auth.log":         {Group: "auth"},
+				".hidden.log":      {Group: ".hidden"},
+				"nginx/access.log": {Group: "nginx"},
+				"nginx/old/a.log":  {Group: "nginx"},
+			},
+			skip: []string{"syslog.1", "nginx/access.log.gz"},
+		},
+		{
+			name:   "group capture",
+			layout: "apps/{group}/*.log",
+			files: map[string]discover.FileInfo{
+				"apps/billing/api.log": {Group: "billing"},
+			},
+			skip: []string{"syslog", "apps/billing/old/api.log"},
+		},
+		{
+			name:   "namespace capture",
+			layout: "{namespace}/{pod}/*.log",
+			files: map[string]discover.FileInfo{
+				"shop/cart/app.log": {Group: "shop"},
+			},
+			skip: []string{"shop/app.log"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, rel := range append(keys(tc.files), tc.skip...) {
+				p := filepath.Join(root, rel)
+				_ = os.MkdirAll(filepath.Dir(p), 0o755)
+				_ = os.WriteFile(p, []byte("x\n"), 0o644)
+			}
+			cfg := hostConfig(t, root)
+			cfg.Defaults.Discovery.Layout = tc.layout
+			got := map[string]string{}
+			for _, f := range discover.New(cfg.Defaults.Discovery, cfg.Overrides).Scan() {
+				rel, _ := filepath.Rel(root, f.Path)
+				got[filepath.ToSlash(rel)] = f.Group
+			}
+			if len(got) != len(tc.files) {
+				t.Fatalf("got %v, want %v", got, tc.files)
+			}
+			for rel, want := range tc.files {
+				if got[rel] != want.Group {
+					t.Fatalf("%s: got group %q, want %q", rel, got[rel], want.Group)
+				}
+			}
+		})
+	}
+}
+
+func TestHostModeIgnoresNamespaceOverrides(t *testing.T) {
+	root := t.TempDir()
+	cfg := hostConfig(t, root)
+	cfg.Overrides = config.Overrides{
+		Namespaces: map[string]config.NamespaceOverride{
+			"nginx": {
+				Policy:    &config.PolicyConfig{Size: 1},
+				Discovery: &config.DiscoveryConfig{Exclude: []string{"**"}},
+			},
+		},
+		Paths: []config.PathOverride{
+			{Match: filepath.ToSlash(root) + "/nginx/*.log", Policy: &config.PolicyConfig{Size: 5 * config.MiB}},
+		},
+	}
+	live := filepath.Join(root, "nginx", "access.log")
+	_ = os.MkdirAll(filepath.Dir(live), 0o755)
+	_ = os.WriteFile(live, []byte("x\n"), 0o644)
+
+	f, ok := discover.New(cfg.Defaults.Discovery, cfg.Overrides).Stat(live)
+	if !ok || f.Group != "nginx" {
+		t.Fatalf("expected %s to be discovered in group nginx, got %+v (%v)", live, f, ok)
+	}
+	e := pol.New(cfg, metrics.NewRegistry())
+	if eff := e.EffectivePolicy(f.Group, live); eff.Size != 5*config.MiB {
+		t.Fatalf("expected the path override's size, got %d", eff.Size)
+	}
+	if eff := e.EffectivePolicy(f.Group, filepath.Join(root, "syslog")); eff.Size != 100*config.MiB {
+		t.Fatalf("expected the default size, got %d", eff.Size)
+	}
+}
+
+func TestHostModeBudgetsPerGroup(t *testing.T) {
+	root := t.TempDir()
+	cfg := hostConfig(t, root)
+	cfg.Defaults.Budgets.PerNamespaceBytes = 10
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rot.Start(ctx)
+	disc := discover.New(cfg.Defaults.Discovery, cfg.Overrides)
+
+	// syslog is over the budget on its own but is another group
+	syslog := filepath.Join(root, "syslog")
+	_ = os.WriteFile(syslog, []byte("x\n"), 0o644)
+	_ = os.WriteFile(syslog+".1", []byte("0123456789abcdef\n"), 0o644)
+	live := filepath.Join(root, "nginx", "access.log")
+	_ = os.MkdirAll(filepath.Dir(live), 0o755)
+	rotate := func(data string) {
+		t.Helper()
+		_ = os.WriteFile(live, []byte(data), 0o644)
+		f, ok := disc.Stat(live)
+		if !ok {
+			t.Fatalf("%s not discovered", live)
+		}
+		if err := rot.ProcessFile(ctx, f, config.PolicyConfig{Size: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotate("first\n")
+	time.Sleep(10 * time.Millisecond)
+	rotate("second\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for util.FileExists(live+".1") && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	for name, want := range map[string]bool{live + ".1": false, live + ".2": true, syslog + ".1": true} {
+		if util.FileExists(name) != want {
+			t.Fatalf("%s: expected exists=%v", name, want)
+		}
+	}
+}
+
+func TestUnknownDiscoveryModeIsRejected(t *testing.T) {
+	cfg := hostConfig(t, t.TempDir())
+	cfg.Defaults.Discovery.Mode = "vm"
+	if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+		t.Fatal("expected an unknown mode to be rejected")
+	}
+}
@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+const containerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestLayoutsCaptureNamespacePodAndContainer(t *testing.T) {
+	cases := []struct {
+		name  string
+		dc    config.DiscoveryConfig
+		files map[string]discover.FileInfo // relative path -> expected captures
+		skip  []string
+	}{
+		{
+			name: "kubelet pods",
+			dc:   config.DiscoveryConfig{Layout: "{namespace}_{pod}_{uid}/{container}/*.log"},
+			files: map[string]discover.FileInfo{
+				"shop_cart-7d9f_1f2e/nginx/0.log": {Namespace: "shop", Pod: "cart-7d9f", Container: "nginx"},
+			},
+			skip: []string{"shop_cart-7d9f_1f2e/0.log", "stray/nginx/0.log"},
+		},
+		{
+			name: "kubelet containers",
+			dc:   config.DiscoveryConfig{Layout: "{pod}_{namespace}_{container}-{id}.log"},
+			files: map[string]discover.FileInfo{
+				"cart-7d9f_shop_side-car-" + containerID + ".log": {Namespace: "shop", Pod: "cart-7d9f", Container: "side-car"},
+			},
+			skip: []string{"shop/cart/app.log"},
+		},
+		{
+			name: "regex",
+			dc:   config.DiscoveryConfig{LayoutRegex: `apps/(?P<namespace>[a-z]+)/(?P<pod>[^/]+)\.log`},
+			files: map[string]discover.FileInfo{
+				"apps/billing/api-1.log": {Namespace: "billing", Pod: "api-1"},
+			},
+			skip: []string{"apps/Billing/api-1.log", "other/billing/api-1.log"},
+		},
+		{
+			name: "default",
+			files: map[string]discover.FileInfo{
+				"ns/pod/app/0.log": {Namespace: "ns", Pod: "pod"},
+			},
+			skip: []string{"ns/app.log"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			for _, rel := range append(tc.skip, keys(tc.files)...) {
+				p := filepath.Join(root, rel)
+				_ = os.MkdirAll(filepath.Dir(p), 0o755)
+				_ = os.WriteFile(p, []byte("x\n"), 0o644)
+			}
+			tc.dc.Path, tc.dc.MaxDepth, tc.dc.Exclude = root, 8, []string{"**/*.gz"}
+			got := map[string]discover.FileInfo{}
+			for _, f := range discover.New(tc.dc, config.Overrides{}).Scan() {
+				rel, _ := filepath.Rel(root, f.Path)
+				got[filepath.ToSlash(rel)] = f
+			}
+			if len(got) != len(tc.files) {
+				t.Fatalf("expected %v, got %v", keys(tc.files), keys(got))
+			}
+			for rel, want := range tc.files {
+				f := got[rel]
+				if f.Namespace != want.Namespace || f.Pod != want.Pod || f.Container != want.Container {
+					t.Fatalf("%s: got %+v, want %+v", rel, f, want)
+				}
+			}
+		})
+	}
+}
+
+func keys(m map[string]discover.FileInfo) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestInvalidLayoutsAreRejected(t *testing.T) {
+	for _, dc := range []config.DiscoveryConfig{
+		{Layout: "{namespace}/*.log"},
+		{Layout: "{namespace}/{pod"},
+		{Layout: "{namespace}/{pod}/{pod}.log"},
+		{LayoutRegex: `(?P<namespace>[^/]+)/(?P<pod>[^/]+`},
+		{Layout: "{namespace}/{pod}/**", LayoutRegex: `(?P<namespace>.+)/(?P<pod>.+)`},
+	} {
+		if _, err := discover.ParseLayout(dc); err == nil {
+			t.Fatalf("expected %+v to be rejected", dc)
+		}
+	}
+}
+
+func TestBudgetPurgeFollowsLayout(t *testing.T) {
+	root := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{Path: root, Layout: "{pod}_{namespace}_{container}-{id}.log"},
+			Budgets:   config.BudgetConfig{PerNamespaceBytes: 10},
+		},
+		State:       config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 4},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rot.Start(ctx)
+
+	// every namespace's logs share the root directory
+	other := filepath.Join(root, "web-1_other_app-"+containerID+".log.1")
+	_ = os.WriteFile(other, []byte("0123456789abcdef\n"), 0o644)
+	live := filepath.Join(root, "cart-1_ns_app-"+containerID+".log")
+	pol := config.PolicyConfig{Size: 1}
+	writeAndRotate(t, rot, live, "first\n", pol)
+	time.Sleep(10 * time.Millisecond)
+	writeAndRotate(t, rot, live, "second\n", pol)
+	deadline := time.Now().Add(5 * time.Second)
+	for util.FileExists(live+".1") && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	for name, want := range map[string]bool{live + ".1": false, live + ".2": true, other: true} {
+		if util.FileExists(name) != want {
+			t.Fatalf("%s: expected exists=%v", filepath.Base(name), want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func newTestEngine(t *testing.T, dir string) *engine.Engine {
+	t.Helper()
+	cfg := &config.Config{
+		Defaults: config.Defaults{Discovery: config.DiscoveryConfig{Path: dir}},
+		State:    config.StateConfig{Path: filepath.Join(dir, ".state", "state.json")},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = rot.Close() })
+	return rot
+}
+
+// writeAndRotate replaces the live file's contents and runs one rotation.
+func writeAndRotate(t *testing.T, rot *engine.Engine, live, data string, pol config.PolicyConfig) {
+	t.Helper()
+	if err := os.WriteFile(live, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(live)
+	dev, ino := util.FileID(fi)
+	f := discover.FileInfo{Path: live, Namespace: "ns", Pod: "pod", Size: fi.Size(), ModTimeMs: fi.ModTime().UnixMilli(), Dev: dev, Inode: ino}
+	if err := rot.ProcessFile(context.Background(), f, pol); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShiftNamingKeepsNewestAtOne(t *testing.T) {
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	pol := config.PolicyConfig{Size: 1, ArchiveName: "shift"}
+	for _, data := range []string{"first\n", "second\n", "third\n"} {
+		writeAndRotate(t, rot, live, data, pol)
+	}
+	for name, want := range map[string]string{"app.log.1": "third\n", "app.log.2": "second\n", "app.log.3": "first\n"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(got) != want {
+			t.Fatalf("%s: got %q (%v), want %q", name, got, err, want)
+		}
+	}
+}
+
+func TestTemplatedNamingAndRetention(t *testing.T) {
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	pol := config.PolicyConfig{Size: 1, KeepFiles: 2, ArchiveName: "{base}-{yyyyMMdd-HHmmss}{ext}"}
+	for _, data := range []string{"a\n", "b\n", "c\n"} {
+		writeAndRotate(t, rot, live, data, pol)
+	}
+	re := regexp.MustCompile(`^app-\d{8}-\d{6}\.log(\.\d+)?$`)
+	entries, _ := os.ReadDir(dir)
+	var archives []string
+	for _, e := range entries {
+		if re.MatchString(e.Name()) {
+			archives = append(archives, e.Name())
+		}
+	}
+	if len(archives) != 2 {
+		t.Fatalf("expected keepFiles to leave 2 dated archives, got %v", archives)
+	}
+}
+
+func TestTemplatedArchivesAreNotRotatedAgain(t *testing.T) {
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	pol := config.PolicyConfig{Size: 1, ArchiveName: "{base}-{yyyyMMdd-HHmmss}{ext}"}
+	writeAndRotate(t, rot, live, "a\n", pol)
+	_ = os.WriteFile(live, nil, 0o644)
+	matches, _ := filepath.Glob(filepath.Join(dir, "app-*.log"))
+	if len(matches) != 1 {
+		t.Fatalf("expected one dated archive, got %v", matches)
+	}
+	// discovery reports the archive too, as it matches **/*.log
+	archive := matches[0]
+	fi, _ := os.Stat(archive)
+	f := discover.FileInfo{Path: archive, Namespace: "ns", Pod: "pod", Size: fi.Size(), ModTimeMs: fi.ModTime().UnixMilli()}
+	if err := rot.ProcessFile(context.Background(), f, pol); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := filepath.Glob(filepath.Join(dir, "app-*")); len(got) != 1 || got[0] != archive {
+		t.Fatalf("archive was rotated like a live file: %v", got)
+	}
+}
+
+func TestArchiveDirMirrorsLayout(t *testing.T) {
+	dir := t.TempDir()
+	archDir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	podDir := filepath.Join(dir, "ns", "pod")
+	if err := os.MkdirAll(podDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	live := filepath.Join(podDir, "app.log")
+	pol := config.PolicyConfig{Size: 1, KeepFiles: 2, ArchiveDir: archDir}
+	for _, data := range []string{"a\n", "b\n", "c\n"} {
+		writeAndRotate(t, rot, live, data, pol)
+	}
+	left, _ := filepath.Glob(filepath.Join(podDir, "app.log.*"))
+	if len(left) != 0 {
+		t.Fatalf("archives left beside the live file: %v", left)
+	}
+	moved, _ := filepath.Glob(filepath.Join(archDir, "ns", "pod", "app.log.*"))
+	if len(moved) != 2 {
+		t.Fatalf("expected keepFiles to leave 2 archives under archiveDir, got %v", moved)
+	}
+}
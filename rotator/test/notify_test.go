@@ -0,0 +1,89 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/notify"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func TestWebhookBatchesAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	var got []notify.Event
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var body struct {
+			Events []notify.Event `json:"events"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		got = append(got, body.Events...)
+	}))
+	defer srv.Close()
+
+	m := metrics.NewRegistry()
+	cfg := config.NotifyConfig{
+		Webhooks:      []config.WebhookConfig{{Name: "oncall", URL: srv.URL, Events: []string{notify.BudgetPurged, notify.Error}}},
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	}
+	n := notify.New(cfg, m, util.NewLogger())
+	ctx, cancel := context.WithCancel(context.Background())
+	n.Start(ctx)
+	n.Send(notify.Event{Kind: notify.Rotated, File: "/a.log"}) // not subscribed
+	n.Send(notify.Event{Kind: notify.BudgetPurged, Namespace: "payments", Archive: "/a.log.1"})
+	n.Send(notify.Event{Kind: notify.Error, File: "/b.log", Error: "boom"})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for testutil.ToFloat64(m.NotifyEvents.WithLabelValues("oncall", "delivered")) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("events were not delivered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	n.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 || len(got) != 2 {
+		t.Fatalf("expected one failed and one successful post of 2 events, got %d calls, %+v", calls, got)
+	}
+	if got[0].Kind != notify.BudgetPurged || got[0].Namespace != "payments" || got[1].Error != "boom" {
+		t.Fatalf("unexpected events %+v", got)
+	}
+}
+
+func TestWebhookQueueDropsWhenFull(t *testing.T) {
+	m := metrics.NewRegistry()
+	cfg := config.NotifyConfig{
+		Webhooks:  []config.WebhookConfig{{Name: "slow", URL: "http://127.0.0.1:0"}},
+		QueueSize: 1,
+	}
+	n := notify.New(cfg, m, util.NewLogger())
+	// not started: nothing drains the queue, and Send must still return
+	for i := 0; i < 3; i++ {
+		n.Send(notify.Event{Kind: notify.Rotated})
+	}
+	if got := testutil.ToFloat64(m.NotifyEvents.WithLabelValues("slow", "dropped")); got != 2 {
+		t.Fatalf("expected 2 dropped events, got %v", got)
+	}
+}
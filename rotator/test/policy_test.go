@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
 	pol "github.com/tapasyadubey/log-rotate-util/rotator/internal/policy"
 )
 
@@ -21,7 +22,7 @@ func TestEffectivePolicy_MergeOrder(t *testing.T) {
 			},
 		},
 	}
-	e := pol.New(cfg)
+	e := pol.New(cfg, metrics.NewRegistry())
 	// path override should apply after namespace; size becomes 200Mi, defaultMode remains copytruncate
 	eff := e.EffectivePolicy("payments", "/pang/logs/legacy-service/payments/pod/file.log")
 	if eff.Size != 200*config.MiB {
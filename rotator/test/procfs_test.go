@@ -0,0 +1,66 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/procfs"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// fakeProc adds a process to a fake /proc with fd 3 pointing at target.
+func fakeProc(t *testing.T, root, pid, target, flags string) {
+	t.Helper()
+	for _, d := range []string{"fd", "fdinfo"} {
+		if err := os.MkdirAll(filepath.Join(root, pid, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(target, filepath.Join(root, pid, "fd", "3")); err != nil {
+		t.Fatal(err)
+	}
+	info := "pos:\t0\nflags:\t" + flags + "\nmnt_id:\t1\n"
+	if err := os.WriteFile(filepath.Join(root, pid, "fdinfo", "3"), []byte(info), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHoldersReportsAppendFlag(t *testing.T) {
+	dir := t.TempDir()
+	live := filepath.Join(dir, "app.log")
+	other := filepath.Join(dir, "other.log")
+	for _, p := range []string{live, other} {
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := filepath.Join(dir, "proc")
+	fakeProc(t, root, "100", live, "0102001") // O_WRONLY|O_APPEND
+	fakeProc(t, root, "200", live, "0100002") // O_RDWR
+	fakeProc(t, root, "300", live, "0100000") // O_RDONLY
+	fakeProc(t, root, "400", other, "0102001")
+
+	fi, _ := os.Stat(live)
+	dev, ino := util.FileID(fi)
+	holders, err := procfs.Holders(root, dev, ino)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[int]procfs.Holder{}
+	for _, h := range holders {
+		got[h.PID] = h
+	}
+	if len(got) != 3 {
+		t.Fatalf("expected 3 holders of app.log, got %+v", holders)
+	}
+	if h := got[100]; !h.Write || !h.Append {
+		t.Fatalf("pid 100 should be an append writer: %+v", h)
+	}
+	if h := got[200]; !h.Write || h.Append {
+		t.Fatalf("pid 200 should be a non-append writer: %+v", h)
+	}
+	if h := got[300]; h.Write {
+		t.Fatalf("pid 300 should be a reader: %+v", h)
+	}
+}
@@ -0,0 +1,78 @@
+package test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func TestRenameSignalsWriterToReopen(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("needs sh")
+	}
+	dir := t.TempDir()
+	live := filepath.Join(dir, "daemon.log")
+	// a daemon that keeps its log open and reopens it on SIGHUP
+	script := `exec 3>>"$1"; trap 'exec 3>>"$1"' HUP; while :; do echo tick >&3; sleep 0.05; done`
+	cmd := exec.Command("sh", "-c", script, "sh", live)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = cmd.Process.Kill(); _ = cmd.Wait() }()
+	pidFile := filepath.Join(dir, "daemon.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(cmd.Process.Pid)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for !util.FileExists(live) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	cfg := &config.Config{
+		Defaults: config.Defaults{Discovery: config.DiscoveryConfig{Path: dir}},
+		State:    config.StateConfig{Path: filepath.Join(dir, ".state", "state.json")},
+	}
+	m := metrics.NewRegistry()
+	rot, err := engine.New(cfg, m, util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(live)
+	f := discover.FileInfo{Path: live, Namespace: "ns", Pod: "pod", Size: fi.Size()}
+	pol := config.PolicyConfig{Size: 1, Reopen: &config.ReopenConfig{PIDFile: pidFile, Verify: 5 * time.Second}}
+	if err := rot.ProcessFile(context.Background(), f, pol); err != nil {
+		t.Fatal(err)
+	}
+	// Close waits for the reopen check
+	if err := rot.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(m.Reopens.WithLabelValues("ns", "reopened")); got != 1 {
+		t.Fatalf("expected the writer to reopen, got %v", got)
+	}
+}
+
+func TestStopSignalsAreNotReopenSignals(t *testing.T) {
+	for _, sig := range []string{"TERM", "SIGINT", "KILL"} {
+		dir := t.TempDir()
+		cfg := &config.Config{
+			Defaults: config.Defaults{Policy: config.PolicyConfig{Reopen: &config.ReopenConfig{Signal: sig}}},
+			State:    config.StateConfig{Path: filepath.Join(dir, "state.json")},
+		}
+		if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+			t.Fatalf("expected reopen signal %s to be rejected", sig)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	pol "github.com/tapasyadubey/log-rotate-util/rotator/internal/policy"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func twoRoots(t *testing.T) (*config.Config, string, string) {
+	t.Helper()
+	pang, pods := t.TempDir(), t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{MaxDepth: 8, Include: []string{"**/*.log"}, Exclude: []string{"**/*.gz"}},
+			Policy:    config.PolicyConfig{Size: 100 * config.MiB},
+			Budgets:   config.BudgetConfig{PerNamespaceBytes: 1 << 30},
+		},
+		Roots: []config.RootConfig{
+			{Name: "pang", Discovery: config.DiscoveryConfig{Path: pang}},
+			{
+				Name:      "pods",
+				Discovery: config.DiscoveryConfig{Path: pods, Layout: "{namespace}_{pod}_{uid}/{container}/*.log"},
+				Policy:    &config.PolicyConfig{Size: 10 * config.MiB},
+				Budgets:   &config.BudgetConfig{PerNamespaceBytes: 10},
+			},
+		},
+		State:       config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 4},
+	}
+	return cfg, pang, pods
+}
+
+func TestRootsHaveTheirOwnLayoutAndPolicy(t *testing.T) {
+	cfg, pang, pods := twoRoots(t)
+	files := map[string]string{
+		filepath.Join(pang, "shop", "cart", "app.log"):             "pang",
+		filepath.Join(pods, "shop_cart_1f2e", "nginx", "0.log"):    "pods",
+		filepath.Join(pang, "shop", "cart", "app.out"):             "", // defaults' include
+		filepath.Join(pods, "shop_cart_1f2e", "nginx", "0.log.gz"): "",
+	}
+	for p := range files {
+		_ = os.MkdirAll(filepath.Dir(p), 0o755)
+		_ = os.WriteFile(p, []byte("x\n"), 0o644)
+	}
+	disc := discover.NewRoots(cfg.DiscoveryRoots(), cfg.Overrides, 2)
+	got := map[string]string{}
+	for _, f := range disc.Scan() {
+		if f.Namespace != "shop" || f.Pod != "cart" {
+			t.Fatalf("unexpected captures %+v", f)
+		}
+		got[f.Path] = f.Root
+	}
+	for p, root := range files {
+		if got[p] != root {
+			t.Fatalf("%s: got root %q, want %q", p, got[p], root)
+		}
+	}
+
+	e := pol.New(cfg, metrics.NewRegistry())
+	if eff := e.EffectivePolicy("shop", filepath.Join(pods, "shop_cart_1f2e", "nginx", "0.log")); eff.Size != 10*config.MiB {
+		t.Fatalf("expected the root's size, got %d", eff.Size)
+	}
+	if eff := e.EffectivePolicy("shop", filepath.Join(pang, "shop", "cart", "app.log")); eff.Size != 100*config.MiB {
+		t.Fatalf("expected the default size, got %d", eff.Size)
+	}
+
+	// an override's discovery path confines the namespace to that root
+	cfg.Overrides.Namespaces = map[string]config.NamespaceOverride{"shop": {Discovery: &config.DiscoveryConfig{Path: pods}}}
+	disc = discover.NewRoots(cfg.DiscoveryRoots(), cfg.Overrides, 2)
+	for _, f := range disc.Scan() {
+		if f.Root != "pods" {
+			t.Fatalf("expected only files under %s, got %s", pods, f.Path)
+		}
+	}
+}
+
+func TestRootsKeepSeparateBudgets(t *testing.T) {
+	cfg, pang, pods := twoRoots(t)
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rot.Start(ctx)
+
+	// same namespace in both roots; only the pods root is over its budget
+	pangDir := filepath.Join(pang, "ns", "pod")
+	podsDir := filepath.Join(pods, "ns_pod_1f2e", "app")
+	_ = os.MkdirAll(pangDir, 0o755)
+	_ = os.MkdirAll(podsDir, 0o755)
+	pangLive := filepath.Join(pangDir, "app.log")
+	podsLive := filepath.Join(podsDir, "0.log")
+	p := config.PolicyConfig{Size: 1}
+	writeAndRotate(t, rot, pangLive, "first\n", p)
+	writeAndRotate(t, rot, pangLive, "second\n", p)
+	writeAndRotate(t, rot, podsLive, "first\n", p)
+	time.Sleep(10 * time.Millisecond)
+	writeAndRotate(t, rot, podsLive, "second\n", p)
+	deadline := time.Now().Add(5 * time.Second)
+	for util.FileExists(podsLive+".1") && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	for name, want := range map[string]bool{podsLive + ".1": false, podsLive + ".2": true, pangLive + ".1": true, pangLive + ".2": true} {
+		if util.FileExists(name) != want {
+			t.Fatalf("%s: expected exists=%v", name, want)
+		}
+	}
+}
+
+func TestOverlappingRootsAreRejected(t *testing.T) {
+	cfg, pang, _ := twoRoots(t)
+	cfg.Roots[1].Discovery.Path = filepath.Join(pang, "nested")
+	if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+		t.Fatal("expected nested roots to be rejected")
+	}
+	cfg.Roots[1].Discovery.Path = t.TempDir()
+	cfg.Roots[1].Name = "pang"
+	if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+		t.Fatal("expected duplicate root names to be rejected")
+	}
+}
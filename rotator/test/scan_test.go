@@ -0,0 +1,92 @@
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+)
+
+func walkSizes(t *testing.T, disc *discover.Engine) (map[string]int64, discover.ScanStats) {
+	t.Helper()
+	got := map[string]int64{}
+	st := disc.Walk(context.Background(), func(f discover.FileInfo) {
+		if _, dup := got[f.Path]; dup {
+			t.Fatalf("%s reported twice", f.Path)
+		}
+		got[f.Path] = f.Size
+	})
+	return got, st
+}
+
+func TestParallelWalkReusesUnchangedListings(t *testing.T) {
+	root := t.TempDir()
+	var want []string
+	for _, ns := range []string{"a", "b", "c", "d", "e"} {
+		for _, pod := range []string{"p1", "p2"} {
+			dir := filepath.Join(root, ns, pod, "app")
+			_ = os.MkdirAll(dir, 0o755)
+			for _, name := range []string{"0.log", "1.log"} {
+				_ = os.WriteFile(filepath.Join(dir, name), []byte("x\n"), 0o644)
+				want = append(want, filepath.Join(dir, name))
+			}
+			_ = os.WriteFile(filepath.Join(dir, "0.log.1.gz"), nil, 0o644)
+			_ = os.Symlink(filepath.Join(dir, "0.log"), filepath.Join(dir, "link.log"))
+		}
+	}
+	// listings are only reused once a directory has settled
+	old := time.Now().Add(-time.Hour)
+	_ = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chtimes(p, old, old)
+		}
+		return nil
+	})
+
+	disc := discover.New(config.DiscoveryConfig{Path: root, MaxDepth: 8, Exclude: []string{"**/*.gz"}, ScanWorkers: 2}, config.Overrides{})
+	got, st := walkSizes(t, disc)
+	var paths []string
+	for p := range got {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	sort.Strings(want)
+	if len(paths) != len(want) {
+		t.Fatalf("expected %v, got %v", want, paths)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Fatalf("expected %v, got %v", want, paths)
+		}
+	}
+	if st.Files != len(want) || st.CachedDirs != 0 {
+		t.Fatalf("unexpected first walk stats %+v", st)
+	}
+
+	// nothing changed: every listing is reused, yet file sizes are fresh
+	grown := filepath.Join(root, "c", "p2", "app", "1.log")
+	_ = os.WriteFile(grown, []byte("x\ny\n"), 0o644)
+	got, st = walkSizes(t, disc)
+	if st.CachedDirs != st.Dirs || st.Files != len(want) {
+		t.Fatalf("expected all listings reused, got %+v", st)
+	}
+	if got[grown] != 4 {
+		t.Fatalf("expected the grown size, got %d", got[grown])
+	}
+
+	// a new file changes its directory's mtime, so that one is read again
+	added := filepath.Join(root, "e", "p1", "app", "2.log")
+	_ = os.WriteFile(added, []byte("z\n"), 0o644)
+	got, st = walkSizes(t, disc)
+	if _, ok := got[added]; !ok {
+		t.Fatalf("new file %s not reported", added)
+	}
+	if st.CachedDirs != st.Dirs-1 {
+		t.Fatalf("expected one directory re-read, got %+v", st)
+	}
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+// fakeS3 stores PUT objects by path and fails with status while it is set.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	status  int
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if r.Method != http.MethodPut || r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) ||
+		!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.objects[r.URL.Path] = body
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for k := range f.objects {
+		out = append(out, k)
+	}
+	return out
+}
+
+func shipConfig(dir, url string) *config.Config {
+	return &config.Config{
+		Defaults: config.Defaults{Discovery: config.DiscoveryConfig{Path: dir}},
+		State:    config.StateConfig{Path: filepath.Join(dir, ".state", "state.json")},
+		Shipping: config.ShipConfig{Endpoint: url, Bucket: "logs", Prefix: "cluster-a", AccessKeyID: "AK", SecretAccessKey: "SK"},
+	}
+}
+
+func TestCompressedArchivesAreShipped(t *testing.T) {
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+	t.Setenv("NODE_NAME", "node-1")
+
+	dir := t.TempDir()
+	pod := filepath.Join(dir, "payments", "api-0")
+	if err := os.MkdirAll(pod, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	rot, err := engine.New(shipConfig(dir, srv.URL), metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	rot.Start(ctx)
+	writeAndRotate(t, rot, filepath.Join(pod, "app.log"), "hello\n", config.PolicyConfig{Size: 1, CompressAfter: time.Nanosecond})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for len(s3.keys()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	keys := s3.keys()
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "/logs/cluster-a/ns/api-0/node-1/") || !strings.HasSuffix(keys[0], "-app.log.1.gz") {
+		t.Fatalf("unexpected uploads %v", keys)
+	}
+}
+
+func TestRetentionKeepsUnshippedArchives(t *testing.T) {
+	s3 := &fakeS3{objects: map[string][]byte{}, status: http.StatusServiceUnavailable}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	rot, err := engine.New(shipConfig(dir, srv.URL), metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rot.Close()
+	live := filepath.Join(dir, "app.log")
+	for _, data := range []string{"a\n", "b\n", "c\n"} {
+		writeAndRotate(t, rot, live, data, config.PolicyConfig{Size: 1, KeepFiles: 1, CompressAfter: time.Hour})
+	}
+	left, _ := filepath.Glob(live + ".*")
+	if len(left) != 3 {
+		t.Fatalf("unshipped archives must survive keepFiles, got %v", left)
+	}
+}
+
+func TestRetentionRemovesArchivesThatAreNeverShipped(t *testing.T) {
+	s3 := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(s3)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	rot, err := engine.New(shipConfig(dir, srv.URL), metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rot.Close()
+	live := filepath.Join(dir, "app.log")
+	// without compression the archives never reach the shipper
+	for _, data := range []string{"a\n", "b\n", "c\n"} {
+		writeAndRotate(t, rot, live, data, config.PolicyConfig{Size: 1, KeepFiles: 1})
+	}
+	left, _ := filepath.Glob(live + ".*")
+	if len(left) != 1 {
+		t.Fatalf("expected keepFiles to leave 1 archive, got %v", left)
+	}
+}
@@ -0,0 +1,187 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func TestCopyTruncateKeepsUnfinishedLine(t *testing.T) {
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	lines := true
+	pol := config.PolicyConfig{Size: 1, DefaultMode: "copytruncate", LineBoundary: &lines}
+	writeAndRotate(t, rot, live, "{\"a\":1}\n{\"b\":2}\n{\"c\":", pol)
+
+	archived, err := os.ReadFile(live + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(archived) != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Fatalf("archive should end at the last newline, got %q", archived)
+	}
+	kept, _ := os.ReadFile(live)
+	if string(kept) != "{\"c\":" {
+		t.Fatalf("live file should keep the unfinished line, got %q", kept)
+	}
+}
+
+func TestCopyTruncateKeepsOrderWithConcurrentWriter(t *testing.T) {
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	w, err := os.OpenFile(live, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// numbered tokens, ten to a line, each its own write: the writer is
+	// usually in the middle of a line when the file is truncated
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for n := 1; ; n++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tok := strconv.Itoa(n) + ","
+			if n%10 == 0 {
+				tok += "\n"
+			}
+			if _, err := w.WriteString(tok); err != nil {
+				return
+			}
+		}
+	}()
+	lines := true
+	pol := config.PolicyConfig{Size: 1, DefaultMode: "copytruncate", LineBoundary: &lines}
+	for i := 0; i < 20; i++ {
+		time.Sleep(2 * time.Millisecond)
+		fi, _ := os.Stat(live)
+		f := discover.FileInfo{Path: live, Namespace: "ns", Pod: "pod", Size: fi.Size()}
+		if err := rot.ProcessFile(context.Background(), f, pol); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	<-done
+	w.Close()
+
+	// bytes written during a truncate may be lost, but none may move ahead
+	// of what was written before them
+	var all []byte
+	for i := 1; util.FileExists(live + "." + strconv.Itoa(i)); i++ {
+		b, _ := os.ReadFile(live + "." + strconv.Itoa(i))
+		all = append(all, b...)
+	}
+	b, _ := os.ReadFile(live)
+	all = append(all, b...)
+	prev := 0
+	for _, tok := range strings.FieldsFunc(string(all), func(r rune) bool { return r == ',' || r == '\n' }) {
+		n, err := strconv.Atoi(tok)
+		if err != nil || n <= prev {
+			t.Fatalf("token %q after %d: data out of order", tok, prev)
+		}
+		prev = n
+	}
+}
+
+func TestCopyTruncateArchiveKeepsModeAndTimes(t *testing.T) {
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(live, []byte("written an hour ago\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	_ = os.Chtimes(live, old, old)
+	f := discover.FileInfo{Path: live, Namespace: "ns", Pod: "pod", Size: 20}
+	if err := rot.ProcessFile(context.Background(), f, config.PolicyConfig{Size: 1, DefaultMode: "copytruncate"}); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(live + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 || !fi.ModTime().Equal(old) {
+		t.Fatalf("got mode %v mtime %v, want 0600 and %v", fi.Mode().Perm(), fi.ModTime(), old)
+	}
+}
+
+func TestCopyTruncateCopiesLargeFileAndRecordsStrategy(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{
+		Defaults: config.Defaults{Discovery: config.DiscoveryConfig{Path: dir}},
+		State:    config.StateConfig{Path: filepath.Join(dir, ".state", "state.json")},
+	}
+	m := metrics.NewRegistry()
+	rot, err := engine.New(cfg, m, util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rot.Close()
+
+	live := filepath.Join(dir, "legacy.log")
+	data := bytes.Repeat([]byte("0123456789abcdef\n"), 200000)
+	if err := os.WriteFile(live, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := discover.FileInfo{Path: live, Namespace: "ns", Pod: "pod", Size: int64(len(data))}
+	if err := rot.ProcessFile(context.Background(), f, config.PolicyConfig{Size: 1, DefaultMode: "copytruncate"}); err != nil {
+		t.Fatal(err)
+	}
+	archived, err := os.ReadFile(live + ".1")
+	if err != nil || !bytes.Equal(archived, data) {
+		t.Fatalf("archive differs from original (%d of %d bytes, %v)", len(archived), len(data), err)
+	}
+	if n := testutil.CollectAndCount(m.TruncateCopySeconds); n != 1 {
+		t.Fatalf("expected one copy strategy recorded, got %d", n)
+	}
+}
+
+func TestTrimDropsOldestLinesInPlace(t *testing.T) {
+	dir := t.TempDir()
+	rot := newTestEngine(t, dir)
+	live := filepath.Join(dir, "app.log")
+	archive := true
+	pol := config.PolicyConfig{Size: 1, DefaultMode: "trim", TrimKeep: 10, TrimArchive: &archive}
+	writeAndRotate(t, rot, live, "old line 1\nold line 2\nnew line\npart", pol)
+
+	dropped, err := os.ReadFile(live + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dropped) != "old line 1\nold line 2\n" {
+		t.Fatalf("archive should hold the dropped lines, got %q", dropped)
+	}
+	kept, _ := os.ReadFile(live)
+	if got := string(bytes.TrimLeft(kept, "\x00")); got != "new line\npart" {
+		t.Fatalf("live file should keep the newest lines, got %q", got)
+	}
+}
+
+func TestTrimWithoutKeepRejected(t *testing.T) {
+	cfg := &config.Config{
+		Defaults: config.Defaults{Policy: config.PolicyConfig{DefaultMode: "trim"}},
+		State:    config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+	}
+	if _, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger()); err == nil {
+		t.Fatal("expected trim without trimKeep to be rejected")
+	}
+}
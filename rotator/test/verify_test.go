@@ -0,0 +1,119 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/engine"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/metrics"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/util"
+)
+
+func verifyStatuses(cfg *config.Config) map[string]string {
+	got := map[string]string{}
+	engine.Verify(cfg, func(r engine.VerifyResult) { got[filepath.Base(r.Path)] = r.Status })
+	return got
+}
+
+func TestVerifyDetectsDamagedArchives(t *testing.T) {
+	dir := t.TempDir()
+	podDir := filepath.Join(dir, "ns", "pod")
+	_ = os.MkdirAll(podDir, 0o755)
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{Path: dir},
+			Budgets:   config.BudgetConfig{PerNamespaceBytes: 1 << 30},
+		},
+		State:       config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 4},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rot.Start(ctx)
+
+	live := filepath.Join(podDir, "app.log")
+	writeAndRotate(t, rot, live, "compressed\n", config.PolicyConfig{Size: 1, CompressAfter: time.Millisecond})
+	writeAndRotate(t, rot, live, "plain\n", config.PolicyConfig{Size: 1})
+	gz := filepath.Join(podDir, "app.log.1.gz")
+	deadline := time.Now().Add(5 * time.Second)
+	for !util.FileExists(gz) && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	if util.FileExists(filepath.Join(podDir, "app.log.1")) {
+		t.Fatalf("expected the source to be removed after compression")
+	}
+	got := verifyStatuses(cfg)
+	if got["app.log.1.gz"] != engine.VerifyOK || got["app.log.2"] != engine.VerifyOK {
+		t.Fatalf("expected both archives to verify, got %v", got)
+	}
+
+	b, _ := os.ReadFile(gz)
+	b[len(b)-5] ^= 0xff
+	_ = os.WriteFile(gz, b, 0o644)
+	_ = os.Remove(filepath.Join(podDir, "app.log.2"))
+	_ = os.WriteFile(filepath.Join(podDir, "app.log.3"), []byte("copied in\n"), 0o644)
+	got = verifyStatuses(cfg)
+	want := map[string]string{"app.log.1.gz": engine.VerifyMismatch, "app.log.2": engine.VerifyMissing, "app.log.3": engine.VerifyUnrecorded}
+	for name, status := range want {
+		if got[name] != status {
+			t.Fatalf("%s: got %q, want %q (all: %v)", name, got[name], status, got)
+		}
+	}
+}
+
+func TestUncompressedArchiveChecksummedByWorkers(t *testing.T) {
+	dir := t.TempDir()
+	podDir := filepath.Join(dir, "ns", "pod")
+	_ = os.MkdirAll(podDir, 0o755)
+	cfg := &config.Config{
+		Defaults: config.Defaults{
+			Discovery: config.DiscoveryConfig{Path: dir},
+			Budgets:   config.BudgetConfig{PerNamespaceBytes: 1 << 30},
+		},
+		State:       config.StateConfig{Path: filepath.Join(t.TempDir(), "state.json")},
+		Compression: config.CompressionConfig{Workers: 1, QueueSize: 4},
+	}
+	rot, err := engine.New(cfg, metrics.NewRegistry(), util.NewLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rot.Start(ctx)
+
+	live := filepath.Join(podDir, "app.log")
+	writeAndRotate(t, rot, live, "kept as is\n", config.PolicyConfig{Size: 1})
+	// rotation records the archive without reading it; a worker adds the
+	// checksum shortly after
+	var m struct {
+		Archives map[string]engine.ManifestEntry `json:"archives"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		b, _ := os.ReadFile(filepath.Join(podDir, ".rotator-manifest.json"))
+		if json.Unmarshal(b, &m) == nil && m.Archives["app.log.1"].SHA256 != "" {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	_ = rot.Close()
+	if m.Archives["app.log.1"].SHA256 == "" {
+		t.Fatalf("expected the archive to be checksummed, got %+v", m.Archives)
+	}
+	_ = os.WriteFile(filepath.Join(podDir, "app.log.1"), []byte("KEPT AS IS\n"), 0o644)
+	if s := verifyStatuses(cfg)["app.log.1"]; s != engine.VerifyMismatch {
+		t.Fatalf("expected a changed archive to mismatch, got %q", s)
+	}
+}
@@ -0,0 +1,77 @@
+//go:build linux
+
+package test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/config"
+	"github.com/tapasyadubey/log-rotate-util/rotator/internal/discover"
+)
+
+// nextBatch waits for a batch that reports path and returns its entry.
+func nextBatch(t *testing.T, events <-chan []discover.FileInfo, path string) discover.FileInfo {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case batch := <-events:
+			for _, f := range batch {
+				if f.Path == path {
+					return f
+				}
+			}
+		case <-timeout:
+			t.Fatalf("no event for %s", path)
+		}
+	}
+}
+
+func TestWatchReportsNewPodsAndWrites(t *testing.T) {
+	root := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(root, "ns", "old-pod"), 0o755)
+	disc := discover.New(config.DiscoveryConfig{Path: root, MaxDepth: 8, Exclude: []string{"**/*.gz"}}, config.Overrides{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := disc.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	existing := filepath.Join(root, "ns", "old-pod", "app.log")
+	_ = os.WriteFile(existing, []byte("hello\n"), 0o644)
+	if f := nextBatch(t, events, existing); f.Namespace != "ns" || f.Pod != "old-pod" || f.Size != 6 {
+		t.Fatalf("unexpected %+v", f)
+	}
+
+	// a pod started after the watch began, with a nested container dir
+	podDir := filepath.Join(root, "ns", "new-pod", "app")
+	_ = os.MkdirAll(podDir, 0o755)
+	live := filepath.Join(podDir, "0.log")
+	_ = os.WriteFile(live, []byte("first\n"), 0o644)
+	nextBatch(t, events, live)
+
+	fh, _ := os.OpenFile(live, os.O_WRONLY|os.O_APPEND, 0)
+	_, _ = fh.WriteString("second line\n")
+	fh.Close()
+	if f := nextBatch(t, events, live); f.Size != int64(len("first\nsecond line\n")) {
+		t.Fatalf("expected the grown size, got %d", f.Size)
+	}
+
+	cancel()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("events channel not closed after cancel")
+		}
+	}
+}